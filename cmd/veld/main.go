@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/mohaanymo/veld/internal/config"
 	"github.com/mohaanymo/veld/internal/engine"
-	"github.com/mohaanymo/veld/internal/parser"
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/tui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -23,7 +30,7 @@ var (
 )
 
 func main() {
-	cfg := parseFlags()
+	cfg, logs := parseFlags()
 
 	if cfg.ShowVersion {
 		fmt.Printf("veld %s (%s)\n", version, commit)
@@ -47,47 +54,174 @@ func main() {
 		cancel()
 	}()
 
-	if err := run(ctx, cfg); err != nil {
+	if err := run(ctx, cfg, logs); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
-func parseFlags() *config.Config {
+func parseFlags() (*config.Config, *logOutput) {
 	cfg := config.New()
 
 	var headers headerFlags
+	var resolve headerFlags
+	var hostLimits headerFlags
 	var threads int
 	var keyStr string
+	var startStr, endStr string
+	var output string
+	var maxFailures string
+	var maxBandwidth, bandwidthSchedule string
+	var minFreeSpace string
+	var logFile, logFormat string
 	// Core options
 	flag.StringVar(&cfg.URL, "url", "", "")
 	flag.StringVar(&cfg.URL, "u", "", "")
 	flag.StringVar(&cfg.FileName, "filename", "", "")
 	flag.StringVar(&cfg.FileName, "fn", "", "")
+	flag.StringVar(&output, "output", "", "")
+	flag.StringVar(&output, "o", "", "")
 	flag.IntVar(&threads, "threads", config.DefaultThreads, "")
 	flag.IntVar(&threads, "n", config.DefaultThreads, "")
+	flag.BoolVar(&cfg.AdaptiveThreads, "adaptive", false, "")
+	flag.IntVar(&cfg.AdaptiveMinThreads, "min-threads", config.DefaultAdaptiveMin, "")
 	flag.BoolVar(&cfg.ParallelTracks, "parallel-tracks", false, "")
 	flag.BoolVar(&cfg.ParallelTracks, "P", false, "")
+	flag.StringVar(&cfg.Schedule, "schedule", "", "")
 	flag.Var(&headers, "header", "")
 	flag.Var(&headers, "H", "")
 	flag.StringVar(&cfg.Cookies, "cookie", "", "")
+	flag.StringVar(&cfg.CookieFile, "cookies-file", "", "")
+	flag.BoolVar(&cfg.SaveCookies, "save-cookies", false, "")
+	flag.StringVar(&cfg.UserAgent, "user-agent", "", "")
+	flag.StringVar(&cfg.CAFile, "ca-file", "", "")
+	flag.StringVar(&cfg.ClientCert, "client-cert", "", "")
+	flag.StringVar(&cfg.ClientKey, "client-key", "", "")
+	flag.StringVar(&cfg.TLSServerName, "tls-server-name", "", "")
+	flag.StringVar(&cfg.HostHeader, "host-header", "", "")
+	flag.Var(&resolve, "resolve", "")
+	flag.BoolVar(&cfg.Insecure, "insecure", false, "")
+	flag.StringVar(&cfg.Proxy, "proxy", "", "")
+	flag.StringVar(&cfg.SegmentProxy, "segment-proxy", "", "")
+	flag.Var(&hostLimits, "host-limit", "")
+	flag.IntVar(&cfg.RefreshAfter, "refresh-after", config.DefaultRefreshAfter, "")
+	flag.IntVar(&cfg.RetryAttempts, "retries", config.DefaultRetryAttempts, "")
+	flag.DurationVar(&cfg.RetryDelay, "retry-delay", config.DefaultRetryDelay, "")
+	flag.DurationVar(&cfg.RetryMaxTime, "retry-max-time", config.DefaultRetryMaxTime, "")
+	flag.StringVar(&maxBandwidth, "max-bandwidth", "", "")
+	flag.StringVar(&bandwidthSchedule, "bandwidth-schedule", "", "")
+	flag.StringVar(&maxFailures, "max-failures", cfg.FailureTolerance.String(), "")
+	flag.BoolVar(&cfg.NoSegmentCheck, "no-segment-check", false, "")
 	flag.StringVar(&keyStr, "key", "", "comma-separated keys")
 	flag.StringVar(&cfg.TrackSelector, "select-track", "", "")
 	flag.StringVar(&cfg.TrackSelector, "s", "", "")
 	flag.StringVar(&cfg.Format, "format", config.DefaultFormat, "")
 	flag.StringVar(&cfg.Format, "f", config.DefaultFormat, "")
 	flag.StringVar(&cfg.MuxerBackend, "muxer", config.DefaultMuxerBackend, "")
+	flag.BoolVar(&cfg.Pipeline, "pipeline", false, "")
+	flag.StringVar(&cfg.TempDir, "temp-dir", "", "")
+	flag.StringVar(&minFreeSpace, "min-free-space", "", "")
+	flag.BoolVar(&cfg.NoSpaceCheck, "no-space-check", false, "")
+	flag.StringVar(&startStr, "start", "", "")
+	flag.StringVar(&endStr, "end", "", "")
+	flag.BoolVar(&cfg.SkipAds, "skip-ads", false, "")
+	flag.BoolVar(&cfg.ExportEvents, "export-events", false, "")
 	flag.BoolVar(&cfg.NoProgress, "no-progress", false, "")
 	flag.BoolVar(&cfg.Verbose, "verbose", false, "")
 	flag.BoolVar(&cfg.Verbose, "v", false, "")
+	flag.StringVar(&logFile, "log-file", "", "")
+	flag.StringVar(&logFormat, "log-format", "text", "")
 	flag.BoolVar(&cfg.ShowVersion, "version", false, "")
 
 	flag.Usage = printUsage
 	flag.Parse()
 
-	cfg.DecryptionKeys = strings.Split(keyStr, ",")
+	if output == "-" {
+		// The media goes to stdout, so messages go to stderr, see messageOutput
+		cfg.Writer = os.Stdout
+	} else if output != "" {
+		cfg.OutputDir, cfg.FileName = filepath.Split(output)
+	}
+
+	if keyStr != "" {
+		cfg.DecryptionKeys = strings.Split(keyStr, ",")
+	}
 	cfg.Threads = threads
 
+	cfg.Resolve = resolve
+	cfg.HostLimits = hostLimits
+	if cfg.Insecure {
+		fmt.Fprintf(os.Stderr, "Warning: --insecure disables TLS certificate verification\n")
+	}
+
+	if cfg.SaveCookies && cfg.CookieFile == "" {
+		fmt.Fprintf(os.Stderr, "Error: --save-cookies needs --cookies-file\n")
+		os.Exit(1)
+	}
+
+	var err error
+	if cfg.Schedule != "" {
+		if _, err = engine.NewScheduler(cfg.Schedule); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --schedule: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if maxBandwidth != "" {
+		if cfg.MaxBandwidth, err = httpclient.ParseRate(maxBandwidth); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --max-bandwidth: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if minFreeSpace != "" {
+		if cfg.MinFreeSpace, err = config.ParseSize(minFreeSpace); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --min-free-space: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if bandwidthSchedule != "" {
+		if _, err = httpclient.ParseBandwidthSchedule(bandwidthSchedule); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --bandwidth-schedule: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.BandwidthSchedule = bandwidthSchedule
+	}
+	if cfg.FailureTolerance, err = config.ParseTolerance(maxFailures); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --max-failures: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.StartTime, err = parseTimestamp(startStr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --start: %v\n", err)
+		os.Exit(1)
+	}
+	if cfg.EndTime, err = parseTimestamp(endStr); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --end: %v\n", err)
+		os.Exit(1)
+	}
+
+	logs := &logOutput{w: os.Stderr}
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: open --log-file: %v\n", err)
+			os.Exit(1)
+		}
+		logs.w = f
+	}
+	level := slog.LevelInfo
+	if cfg.Verbose {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "text":
+		cfg.Logger = slog.New(slog.NewTextHandler(logs, opts))
+	case "json":
+		cfg.Logger = slog.New(slog.NewJSONHandler(logs, opts))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: invalid --log-format %q, use text or json\n", logFormat)
+		os.Exit(1)
+	}
+
 	// If no track selector provided, show interactive picker
 	if cfg.TrackSelector == "" {
 		cfg.TrackSelector = "interactive"
@@ -100,7 +234,7 @@ func parseFlags() *config.Config {
 			cfg.Headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
 		}
 	}
-	return cfg
+	return cfg, logs
 }
 
 func printUsage() {
@@ -110,17 +244,56 @@ Usage: veld [options] -u <URL>
 
 Options:
   -u, --url <URL>           Stream URL (m3u8 or mpd) [required]
-  -o, --output <path>       Output file path (default: output.mp4)
-  -n, --threads <num>       Concurrent downloads (default: 16)
+  -o, --output <path>       Output file path, or - for stdout (default: output.mp4)
+  -n, --threads <num>       Concurrent downloads, the maximum with --adaptive (default: 16)
+      --adaptive            Tune concurrency at runtime from throughput, errors and latency
+      --min-threads <num>   Lower bound for --adaptive (default: 2)
   -s, --select-track <sel>  Track selection (omit for interactive picker)
   -P, --parallel-tracks     Download all tracks concurrently
+      --schedule <order>    Segment order: sequential, interleaved, parallel-tracks
   -f, --format <fmt>        Output format: mp4, mkv, ts (default: mp4)
   -H, --header <header>     Custom header (repeatable)
       --cookie <cookies>    Cookies for requests
+      --cookies-file <path> Load cookies from a Netscape cookies.txt export
+      --save-cookies        Write cookies updated by the server back to --cookies-file
+      --user-agent <ua>     User-Agent for requests
+      --ca-file <path>      Trust the root CAs in this PEM bundle as well
+      --client-cert <path>  Client certificate (PEM) for mutual TLS
+      --client-key <path>   Key for --client-cert (PEM)
+      --tls-server-name <n> SNI and certificate name for the manifest's host instead of its own
+      --host-header <host>  Host header for requests to the manifest's host
+      --resolve <h:p:addr>  Connect to addr for host:port, keeping Host and SNI (repeatable)
+      --insecure            Skip TLS certificate verification (unsafe)
+      --proxy <url>         Proxy for all requests: http://, https://, socks5:// (user:pass@ allowed),
+                            or direct (default: HTTP_PROXY/HTTPS_PROXY from the environment)
+      --segment-proxy <url> Proxy for media segments only, e.g. direct (default: --proxy)
+      --host-limit <spec>   Per-host limits, pattern:conns=N,rps=N,bw=RATE (repeatable),
+                            e.g. "origin.example.com:conns=4,rps=10" or "*.cdn.net:bw=5M"
+      --refresh-after <n>   Re-fetch the manifest after n 401/403 segment responses, 0 = never (default: 1)
       --key <KID:KEY>       Decryption key (KID:KEY,KID:KEY)
+      --max-bandwidth <r>   Download speed limit in bytes/s, e.g. 500K, 2M (default: unlimited)
+      --bandwidth-schedule <s>
+                            Daily limits overriding --max-bandwidth, in local time,
+                            e.g. "00:00-07:00=unlimited,09:00-18:00=2M"
+      --max-failures <n>    Failed segments allowed: strict, a count or a percentage (default: 1%%)
+      --no-segment-check    Accept segments without checking their length and format
+      --retries <num>       Retries per request after the first attempt (default: 3)
+      --retry-delay <dur>   Initial retry backoff, doubled each retry (default: 1s)
+      --retry-max-time <t>  Give up retrying a request after this long, 0 = no limit (default: 2m)
       --muxer <backend>     Muxer: auto, ffmpeg, binary (default: auto)
+      --pipeline            Mux while downloading, deleting segments once written
+      --temp-dir <dir>      Directory for segments and muxing files (default: system temp dir)
+      --min-free-space <n>  Free space to keep, e.g. 1G; below it the download stops
+                            with progress saved, to resume later (default: 256M)
+      --no-space-check      Skip the disk space estimate and low-disk watch
+      --start <time>        Start of the range to download (e.g. 1:30:00, 90s)
+      --end <time>          End of the range to download (default: end of stream)
+      --skip-ads            Skip segments inside ad breaks
+      --export-events       Save ad markers as chapters and a .events.json sidecar
       --no-progress         Disable TUI progress
-  -v, --verbose             Verbose output
+  -v, --verbose             Log debug diagnostics: requests, retries, FFmpeg output
+      --log-file <path>     Append log records to this file (default: stderr)
+      --log-format <fmt>    Log format: text, json (default: text)
       --version             Show version
 
 Track Selection (-s):
@@ -135,39 +308,48 @@ Examples:
   veld -u https://example.com/video.m3u8           # Interactive picker
   veld -u https://example.com/video.m3u8 -s best   # Auto-select best
   veld -u https://example.com/video.mpd -s 1080p   # 1080p video
+  veld -u https://example.com/video.m3u8 -s best --start 1:00:00 --end 1:02:00
+  veld -u https://example.com/video.m3u8 -s best -o - | ffplay -
 `)
 }
 
-func run(ctx context.Context, cfg *config.Config) error {
-	parserRegistry := parser.NewRegistry()
+func run(ctx context.Context, cfg *config.Config, logs *logOutput) error {
+	out := messageOutput(cfg)
+	messages := &logOutput{w: out}
+	cfg.Messages = messages
 
-	if cfg.Verbose {
-		fmt.Printf("Parsing manifest: %s\n", cfg.URL)
+	eng, err := engine.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create engine: %w", err)
 	}
-	manifest, err := parserRegistry.Parse(ctx, cfg.URL, cfg.Headers)
+	defer func() {
+		if err := eng.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}()
+
+	cfg.Logger.DebugContext(ctx, "parsing manifest", "url", httpclient.RedactURL(cfg.URL))
+	manifest, err := eng.ParseManifest(ctx, cfg.URL)
 	if err != nil {
 		return fmt.Errorf("failed to parse manifest: %w", err)
 	}
 
-	fmt.Printf("Found %d tracks\n", len(manifest.Tracks))
-
-	eng, err := engine.New(cfg)
-	if err != nil {
-		return fmt.Errorf("failed to create engine: %w", err)
+	fmt.Fprintf(out, "Found %d tracks\n", len(manifest.Tracks))
+	if n := len(manifest.AdBreaks()); n > 0 {
+		fmt.Fprintf(out, "Found %d ad breaks\n", n)
 	}
-	defer eng.Close()
 
 	// Handle track selection
 	if cfg.TrackSelector == "interactive" {
 		picker := tui.NewTrackPicker(manifest.Tracks)
-		p := tea.NewProgram(picker, tea.WithAltScreen())
+		p := tea.NewProgram(picker, tea.WithAltScreen(), tea.WithOutput(out))
 		if _, err := p.Run(); err != nil {
 			return fmt.Errorf("track picker error: %w", err)
 		}
 
 		result := picker.Result()
 		if result.Canceled {
-			fmt.Println("Canceled")
+			fmt.Fprintln(out, "Canceled")
 			return nil
 		}
 		if len(result.Selected) == 0 {
@@ -180,18 +362,14 @@ func run(ctx context.Context, cfg *config.Config) error {
 		}
 	}
 
-	fmt.Printf("Selected %d tracks\n", len(eng.SelectedTracks))
+	fmt.Fprintf(out, "Selected %d tracks\n", len(eng.SelectedTracks))
 	for _, t := range eng.SelectedTracks {
-		fmt.Printf("  - %s: %s %s\n", t.Type, t.Resolution.QualityLabel(), t.Codec)
+		fmt.Fprintf(out, "  - %s: %s %s\n", t.Type, t.Resolution.QualityLabel(), t.Codec)
 	}
 
-	// Pre-load segments for lazy-loaded tracks before TUI
-	for _, track := range eng.SelectedTracks {
-		if track.MediaPlaylistURL != "" && len(track.Segments) == 0 {
-			if err := eng.LoadTrackSegments(ctx, track); err != nil {
-				return fmt.Errorf("load segments for %s: %w", track.ID, err)
-			}
-		}
+	// Pre-load segments for lazy-loaded tracks and apply the time range before TUI
+	if err := eng.PrepareTracks(ctx, manifest); err != nil {
+		return err
 	}
 
 	if cfg.NoProgress {
@@ -199,13 +377,19 @@ func run(ctx context.Context, cfg *config.Config) error {
 		if err != nil {
 			return err
 		}
-		printFileName(cfg)
+		printFileName(out, cfg)
 		return nil
 	}
 
 	// Run with TUI
 	model := tui.NewModel(eng, manifest, cfg)
-	p := tea.NewProgram(model, tea.WithAltScreen())
+	p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithOutput(out))
+
+	// Log records and messages would garble the TUI
+	logs.hold()
+	defer logs.release()
+	messages.hold()
+	defer messages.release()
 
 	var downloadErr error
 	go func() {
@@ -220,16 +404,30 @@ func run(ctx context.Context, cfg *config.Config) error {
 	if _, err := p.Run(); err != nil {
 		return fmt.Errorf("TUI error: %w", err)
 	}
+	logs.release()
+	messages.release()
 
 	if downloadErr != nil {
 		return downloadErr
 	}
 
-	printFileName(cfg)
+	printFileName(out, cfg)
 	return nil
 }
 
-func printFileName(cfg *config.Config) {
+// messageOutput returns where progress messages and the TUI go: stdout,
+// or stderr when the media itself is written to stdout.
+func messageOutput(cfg *config.Config) io.Writer {
+	if cfg.Writer == os.Stdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func printFileName(out io.Writer, cfg *config.Config) {
+	if cfg.Writer != nil {
+		return
+	}
 	filename := cfg.FileName
 	if filename == "" {
 		filename = "filename"
@@ -237,7 +435,83 @@ func printFileName(cfg *config.Config) {
 	if !strings.HasSuffix(strings.ToLower(filename), "."+cfg.Format) {
 		filename = filename + "." + cfg.Format
 	}
-	fmt.Printf("\n✓ Saved to: %s\n", filename)
+	fmt.Fprintf(out, "\n✓ Saved to: %s\n", filename)
+}
+
+// parseTimestamp parses a position like "90s", "1m30s", "1:30" or "01:02:03.5".
+// An empty string yields 0.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("too many fields in %q", s)
+	}
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
+
+// maxHeld is how much output logOutput holds back; later records are
+// dropped, so a long -v download behind the TUI doesn't grow memory.
+const maxHeld = 1 << 20
+
+// logOutput is where log records or messages go: the --log-file, stderr
+// or stdout. Records for the terminal are held back while the TUI owns it.
+type logOutput struct {
+	mu      sync.Mutex
+	w       io.Writer
+	held    *bytes.Buffer
+	dropped int // Records over maxHeld
+}
+
+func (l *logOutput) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != nil {
+		if l.held.Len()+len(p) > maxHeld {
+			l.dropped++
+			return len(p), nil
+		}
+		return l.held.Write(p)
+	}
+	return l.w.Write(p)
+}
+
+// hold buffers records meant for the terminal until release.
+func (l *logOutput) hold() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.w == os.Stderr || l.w == os.Stdout {
+		l.held = new(bytes.Buffer)
+	}
+}
+
+// release writes the held records and stops holding; it may be called
+// more than once.
+func (l *logOutput) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != nil {
+		l.w.Write(l.held.Bytes())
+		if l.dropped > 0 {
+			fmt.Fprintf(l.w, "(%d more records dropped while the progress display was shown)\n", l.dropped)
+		}
+		l.held = nil
+		l.dropped = 0
+	}
 }
 
 // headerFlags implements flag.Value for repeatable header flags
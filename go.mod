@@ -6,6 +6,8 @@ require (
 	github.com/Eyevinn/mp4ff v0.50.0
 	github.com/charmbracelet/bubbletea v1.3.10
 	github.com/charmbracelet/lipgloss v1.1.0
+	golang.org/x/net v0.44.0
+	golang.org/x/time v0.14.0
 )
 
 require (
@@ -25,6 +27,5 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
 	golang.org/x/sys v0.36.0 // indirect
-	golang.org/x/text v0.3.8 // indirect
-	golang.org/x/time v0.14.0 // indirect
+	golang.org/x/text v0.29.0 // indirect
 )
@@ -2,7 +2,16 @@
 package config
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -11,6 +20,7 @@ var (
 	ErrMissingURL      = errors.New("URL is required")
 	ErrInvalidFormat   = errors.New("invalid output format")
 	ErrInvalidSelector = errors.New("invalid track selector")
+	ErrInvalidRange    = errors.New("invalid time range")
 )
 
 // Config holds all application configuration.
@@ -21,19 +31,63 @@ type Config struct {
 	// Output
 	FileName  string
 	OutputDir string
-	Format    string // mp4, mkv, ts
+	Format    string    // mp4, mkv, ts
+	Writer    io.Writer // stream the output here instead of to a file
 
 	// Download settings
-	Threads        int
-	ParallelTracks bool
-	RetryAttempts  int
-	RetryDelay     time.Duration
-	Timeout        time.Duration
-	MaxBandwidth   int64 // bytes per second, 0 = unlimited
+	Threads            int
+	AdaptiveThreads    bool          // tune active threads at runtime, Threads is the maximum
+	AdaptiveMinThreads int           // lower bound for adaptive threads
+	ParallelTracks     bool          // download tracks side by side (parallel-tracks schedule)
+	Schedule           string        // segment order: sequential, interleaved, parallel-tracks ("" = auto)
+	RetryAttempts      int           // retries after the first attempt
+	RetryDelay         time.Duration // backoff before the first retry, doubled on each retry
+	RetryMaxTime       time.Duration // give up retrying a request after this long, 0 = no limit
+	Timeout            time.Duration // manifests and keys; segments fail after this long without data
+	MaxBandwidth       int64         // bytes per second, 0 = unlimited
+
+	// Daily windows overriding MaxBandwidth, e.g. full speed at night:
+	// "HH:MM-HH:MM=RATE,..."
+	BandwidthSchedule string
+
+	// Share of a Manager's global bandwidth limit, relative to other
+	// downloads, 0 = 1
+	BandwidthWeight float64
+
+	// Failed segments allowed before a download fails
+	FailureTolerance Tolerance
+
+	// Accept segment bodies without checking their length and format
+	NoSegmentCheck bool
 
 	// HTTP settings
-	Headers map[string]string
-	Cookies string
+	HTTPClient   *http.Client // used for all requests instead of veld's own transport
+	Headers      map[string]string
+	Cookies      string
+	UserAgent    string   // "" = Go's default
+	CookieFile   string   // Netscape cookies.txt loaded into the cookie jar
+	SaveCookies  bool     // write the jar back to CookieFile on Close
+	Proxy        string   // proxy URL for all requests, "direct" = none, "" = from environment
+	SegmentProxy string   // proxy for media segments only, "" = same as Proxy
+	HostLimits   []string // per-host policies, "pattern:conns=N,rps=N,bw=RATE"
+
+	// TLS and connection settings, for all requests
+	CAFile     string   // PEM bundle of extra trusted root CAs
+	ClientCert string   // PEM client certificate for mutual TLS
+	ClientKey  string   // PEM key of ClientCert
+	Insecure   bool     // skip certificate verification
+	Resolve    []string // "host:port:address" connection overrides
+
+	// Names sent to the manifest's host instead of its own, e.g. to test an
+	// origin pinned with Resolve. Other hosts are not affected.
+	TLSServerName string // SNI and certificate name
+	HostHeader    string // Host header
+
+	// Signed URL refresh: after RefreshAfter 401/403 responses the manifest
+	// is fetched again (from RefreshURL's result, if set) for new segment
+	// URLs. 0 = never refresh.
+	RefreshAfter int
+	RefreshURL   func(ctx context.Context) (string, error)
 
 	// Encryption
 	DecryptionKeys []string
@@ -41,40 +95,68 @@ type Config struct {
 	// Track selection
 	TrackSelector string
 
+	// Time range (VOD clipping), EndTime 0 = until the end
+	StartTime time.Duration
+	EndTime   time.Duration
+
+	// Ad markers
+	SkipAds      bool // drop segments inside ad breaks
+	ExportEvents bool // write markers as chapters and a JSON sidecar
+
 	// Muxer backend
 	MuxerBackend string // ffmpeg, binary, auto
+	Pipeline     bool   // mux while downloading, deleting segments once written
+
+	// Disk space
+	TempDir      string // segments and mux intermediates, "" = system temp dir
+	MinFreeSpace int64  // bytes to keep free; below this the download stops with progress saved
+	NoSpaceCheck bool   // skip the space estimate and the low-disk watch
 
 	// UI/Logging
 	NoProgress  bool
-	Verbose     bool
+	Verbose     bool         // log diagnostics to stderr when Logger is nil
+	Logger      *slog.Logger // receives all diagnostics, nil = see Log
+	Messages    io.Writer    // user-facing notes such as saved subtitle files, nil = none
 	ShowVersion bool
 }
 
 // Default configuration values.
 const (
 	DefaultThreads       = 16
+	DefaultAdaptiveMin   = 2
 	DefaultFormat        = "mp4"
 	DefaultMuxerBackend  = "auto"
 	DefaultRetryAttempts = 3
 	DefaultRetryDelay    = time.Second
+	DefaultRetryMaxTime  = 2 * time.Minute
 	DefaultTimeout       = 30 * time.Second
+	DefaultRefreshAfter  = 1
 	DefaultTrackSelector = "best"
+	DefaultMinFreeSpace  = 256 << 20
 
 	MaxThreads = 128
 	MinThreads = 1
 )
 
+// DefaultTolerance allows up to 1% of segments to fail.
+var DefaultTolerance = Tolerance{MaxPercent: 1}
+
 // New returns a Config with sensible defaults.
 func New() *Config {
 	return &Config{
-		Threads:       DefaultThreads,
-		Format:        DefaultFormat,
-		MuxerBackend:  DefaultMuxerBackend,
-		RetryAttempts: DefaultRetryAttempts,
-		RetryDelay:    DefaultRetryDelay,
-		Timeout:       DefaultTimeout,
-		TrackSelector: DefaultTrackSelector,
-		Headers:       make(map[string]string),
+		Threads:            DefaultThreads,
+		AdaptiveMinThreads: DefaultAdaptiveMin,
+		Format:             DefaultFormat,
+		MuxerBackend:       DefaultMuxerBackend,
+		RetryAttempts:      DefaultRetryAttempts,
+		RetryDelay:         DefaultRetryDelay,
+		RetryMaxTime:       DefaultRetryMaxTime,
+		Timeout:            DefaultTimeout,
+		TrackSelector:      DefaultTrackSelector,
+		MinFreeSpace:       DefaultMinFreeSpace,
+		Headers:            make(map[string]string),
+
+		FailureTolerance: DefaultTolerance,
 	}
 }
 
@@ -91,6 +173,15 @@ func (c *Config) Validate() error {
 	if c.Threads > MaxThreads {
 		c.Threads = MaxThreads
 	}
+	c.AdaptiveMinThreads = max(MinThreads, min(c.AdaptiveMinThreads, c.Threads))
+
+	if c.RetryAttempts < 0 {
+		c.RetryAttempts = 0
+	}
+
+	if c.StartTime < 0 || c.EndTime < 0 || (c.EndTime > 0 && c.EndTime <= c.StartTime) {
+		return ErrInvalidRange
+	}
 
 	// Initialize headers map if nil
 	if c.Headers == nil {
@@ -99,3 +190,117 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// HasTimeRange reports whether only part of the stream should be downloaded.
+func (c *Config) HasTimeRange() bool {
+	return c.StartTime > 0 || c.EndTime > 0
+}
+
+// TempBase returns the directory for temporary files: TempDir, or the
+// system temp dir.
+func (c *Config) TempBase() string {
+	if c.TempDir != "" {
+		return c.TempDir
+	}
+	return os.TempDir()
+}
+
+// Log returns the logger for diagnostics: Logger if set, else a text
+// logger to stderr at debug level with Verbose, else one that discards
+// everything.
+func (c *Config) Log() *slog.Logger {
+	switch {
+	case c.Logger != nil:
+		return c.Logger
+	case c.Verbose:
+		return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	default:
+		return slog.New(slog.DiscardHandler)
+	}
+}
+
+// Tolerance limits how many segments may fail before a download is
+// considered failed. The zero value is strict: no failures are allowed.
+type Tolerance struct {
+	MaxCount   int     // failed segments allowed, 0 = no count limit
+	MaxPercent float64 // percentage of segments allowed to fail, 0 = no percentage limit
+}
+
+// ParseTolerance parses "strict", a count such as "10" or a percentage
+// such as "2.5%".
+func ParseTolerance(s string) (Tolerance, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "strict", "0", "0%":
+		return Tolerance{}, nil
+	}
+
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil || v < 0 || v > 100 {
+			return Tolerance{}, fmt.Errorf("invalid failure percentage %q", s)
+		}
+		return Tolerance{MaxPercent: v}, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return Tolerance{}, fmt.Errorf("invalid failure tolerance %q (want strict, a count or a percentage)", s)
+	}
+	return Tolerance{MaxCount: n}, nil
+}
+
+// Strict reports whether no failures are allowed.
+func (t Tolerance) Strict() bool {
+	return t.MaxCount <= 0 && t.MaxPercent <= 0
+}
+
+// Allows reports whether failed out of total segments is acceptable.
+func (t Tolerance) Allows(failed, total int) bool {
+	if failed == 0 {
+		return true
+	}
+	if t.Strict() {
+		return false
+	}
+	if t.MaxCount > 0 && failed > t.MaxCount {
+		return false
+	}
+	if t.MaxPercent > 0 && float64(failed)*100 > t.MaxPercent*float64(max(total, 1)) {
+		return false
+	}
+	return true
+}
+
+// String returns the tolerance in the form accepted by ParseTolerance,
+// except when both limits are set.
+func (t Tolerance) String() string {
+	switch {
+	case t.Strict():
+		return "strict"
+	case t.MaxCount > 0 && t.MaxPercent > 0:
+		return fmt.Sprintf("%d and %g%%", t.MaxCount, t.MaxPercent)
+	case t.MaxCount > 0:
+		return strconv.Itoa(t.MaxCount)
+	default:
+		return fmt.Sprintf("%g%%", t.MaxPercent)
+	}
+}
+
+// ParseSize parses a byte count such as "512", "256M" or "1.5G". K, M, G
+// and T are powers of 1024 and may be followed by "B".
+func ParseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	if n := len(num); n > 0 {
+		if i := strings.IndexByte("KMGT", num[n-1]); i >= 0 {
+			mult = 1 << (10 * (i + 1))
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 || v*float64(mult) > math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q (want bytes, optionally with a K, M, G or T suffix)", s)
+	}
+	return int64(v * float64(mult)), nil
+}
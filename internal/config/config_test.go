@@ -0,0 +1,75 @@
+package config
+
+import "testing"
+
+func TestParseTolerance(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Tolerance
+		wantErr bool
+	}{
+		{"strict", Tolerance{}, false},
+		{"0", Tolerance{}, false},
+		{"10", Tolerance{MaxCount: 10}, false},
+		{"2.5%", Tolerance{MaxPercent: 2.5}, false},
+		{"150%", Tolerance{}, true},
+		{"-1", Tolerance{}, true},
+		{"some", Tolerance{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTolerance(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTolerance(%q) = %+v, %v; want %+v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+		if err == nil {
+			if back, _ := ParseTolerance(got.String()); back != got {
+				t.Errorf("ParseTolerance(%q).String() = %q does not round-trip", tt.in, got.String())
+			}
+		}
+	}
+}
+
+func TestToleranceAllows(t *testing.T) {
+	tests := []struct {
+		tol           Tolerance
+		failed, total int
+		want          bool
+	}{
+		{Tolerance{}, 0, 100, true},
+		{Tolerance{}, 1, 100, false},
+		{Tolerance{MaxCount: 2}, 2, 10, true},
+		{Tolerance{MaxCount: 2}, 3, 1000, false},
+		{Tolerance{MaxPercent: 1}, 1, 100, true},
+		{Tolerance{MaxPercent: 1}, 2, 100, false},
+		{Tolerance{MaxCount: 5, MaxPercent: 1}, 3, 100, false},
+	}
+	for _, tt := range tests {
+		if got := tt.tol.Allows(tt.failed, tt.total); got != tt.want {
+			t.Errorf("%+v.Allows(%d, %d) = %v, want %v", tt.tol, tt.failed, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"512", 512, false},
+		{"256M", 256 << 20, false},
+		{"1.5g", 3 << 29, false},
+		{"2GB", 2 << 30, false},
+		{"1T", 1 << 40, false},
+		{"1G/s", 0, true},
+		{"-1M", 0, true},
+		{"lots", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
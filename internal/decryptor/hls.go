@@ -7,8 +7,11 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"sync"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
 )
 
 // HLSDecryptor handles AES-128 decryption for HLS streams.
@@ -17,6 +20,8 @@ type HLSDecryptor struct {
 	mu       sync.RWMutex
 	client   *http.Client
 	headers  map[string]string
+	retry    httpclient.RetryPolicy
+	log      *slog.Logger
 }
 
 // NewHLSDecryptor creates a new HLS decryptor.
@@ -28,9 +33,21 @@ func NewHLSDecryptor(client *http.Client, headers map[string]string) *HLSDecrypt
 		keyCache: make(map[string][]byte),
 		client:   client,
 		headers:  headers,
+		retry:    httpclient.DefaultRetryPolicy(),
+		log:      slog.New(slog.DiscardHandler),
 	}
 }
 
+// SetRetryPolicy sets how failed key fetches are retried.
+func (d *HLSDecryptor) SetRetryPolicy(policy httpclient.RetryPolicy) {
+	d.retry = policy
+}
+
+// SetLogger sets the logger for key fetches.
+func (d *HLSDecryptor) SetLogger(log *slog.Logger) {
+	d.log = log
+}
+
 // FetchKey retrieves the decryption key from the given URI.
 // Keys are cached by URI to avoid redundant fetches.
 func (d *HLSDecryptor) FetchKey(ctx context.Context, keyURI string) ([]byte, error) {
@@ -41,9 +58,30 @@ func (d *HLSDecryptor) FetchKey(ctx context.Context, keyURI string) ([]byte, err
 	}
 	d.mu.RUnlock()
 
+	redacted := httpclient.RedactURL(keyURI)
+	var key []byte
+	err := d.retry.Logged(d.log, "url", redacted).Do(ctx, func(int) error {
+		var err error
+		key, err = d.fetchKey(ctx, keyURI)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.keyCache[keyURI] = key
+	d.mu.Unlock()
+	d.log.DebugContext(ctx, "fetched key", "url", redacted)
+
+	return key, nil
+}
+
+// fetchKey makes a single attempt at downloading a key.
+func (d *HLSDecryptor) fetchKey(ctx context.Context, keyURI string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keyURI, nil)
 	if err != nil {
-		return nil, fmt.Errorf("create key request: %w", err)
+		return nil, httpclient.Permanent(fmt.Errorf("create key request: %w", err))
 	}
 
 	for k, v := range d.headers {
@@ -56,8 +94,8 @@ func (d *HLSDecryptor) FetchKey(ctx context.Context, keyURI string) ([]byte, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("key fetch failed: HTTP %d", resp.StatusCode)
+	if err := httpclient.CheckResponse(resp); err != nil {
+		return nil, fmt.Errorf("key fetch failed: %w", err)
 	}
 
 	key, err := io.ReadAll(resp.Body)
@@ -66,16 +104,23 @@ func (d *HLSDecryptor) FetchKey(ctx context.Context, keyURI string) ([]byte, err
 	}
 
 	if len(key) != 16 {
-		return nil, fmt.Errorf("invalid key length: expected 16 bytes, got %d", len(key))
+		return nil, httpclient.Permanent(fmt.Errorf("invalid key length: expected 16 bytes, got %d", len(key)))
 	}
-
-	d.mu.Lock()
-	d.keyCache[keyURI] = key
-	d.mu.Unlock()
-
 	return key, nil
 }
 
+// PrefetchKeys fetches and caches keys ahead of segment downloads
+// (e.g. from EXT-X-SESSION-KEY). It returns the first error encountered.
+func (d *HLSDecryptor) PrefetchKeys(ctx context.Context, keyURIs []string) error {
+	var firstErr error
+	for _, uri := range keyURIs {
+		if _, err := d.FetchKey(ctx, uri); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 // Decrypt decrypts data using AES-128-CBC with the given key and IV.
 // If iv is nil, it defaults to the first 16 bytes of the data (or zero IV).
 func (d *HLSDecryptor) Decrypt(data, key, iv []byte) ([]byte, error) {
@@ -0,0 +1,51 @@
+package decryptor
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+)
+
+func TestPrefetchKeys(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 16)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/short" {
+			w.Write([]byte("not a key"))
+			return
+		}
+		w.Write(key)
+	}))
+	defer srv.Close()
+
+	d := NewHLSDecryptor(srv.Client(), nil)
+	d.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	ctx := context.Background()
+
+	err := d.PrefetchKeys(ctx, []string{srv.URL + "/k1", srv.URL + "/short", srv.URL + "/k2"})
+	if err == nil {
+		t.Error("invalid key not reported")
+	}
+	// The bad key is permanent and not retried, and doesn't stop the others
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+
+	// Prefetched keys are served from the cache
+	for _, uri := range []string{srv.URL + "/k1", srv.URL + "/k2"} {
+		got, err := d.FetchKey(ctx, uri)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("FetchKey(%s) = %x, %v", uri, got, err)
+		}
+	}
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests after prefetch, want 3", n)
+	}
+}
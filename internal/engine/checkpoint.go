@@ -2,19 +2,65 @@ package engine
 
 import (
 	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
 )
 
+// checkpointSaveInterval is how often unsaved checkpoint changes are written.
+const checkpointSaveInterval = 2 * time.Second
+
+// tempDirPrefix starts the name of every segment directory veld creates.
+const tempDirPrefix = "veld_"
+
 // Checkpoint tracks download progress for resume capability.
 type Checkpoint struct {
-	URL           string           `json:"url"`
-	TempDir       string           `json:"temp_dir"`
-	CompletedSegs map[string][]int `json:"completed"` // trackID -> segment indices
-	CreatedAt     time.Time        `json:"created_at"`
-	mu            sync.Mutex
+	URL         string                   `json:"url"`
+	TempDir     string                   `json:"temp_dir"`
+	Tracks      []string                 `json:"tracks"`      // Selected track IDs
+	Fingerprint string                   `json:"fingerprint"` // ManifestFingerprint of the selected tracks
+	Segments    map[string]SegmentRecord `json:"segments"`    // "trackID/index" -> completed segment file
+	CreatedAt   time.Time                `json:"created_at"`
+
+	// Partially downloaded segment bodies, keyed by "trackID/index"
+	Partial map[string]PartialSegment `json:"partial,omitempty"`
+
+	mu    sync.Mutex
+	dirty bool // Changed since the last Save
+}
+
+// SegmentRecord describes a completed segment file so it can be verified
+// before it is reused.
+type SegmentRecord struct {
+	Size int64  `json:"size"`
+	Hash string `json:"crc32c"`
+}
+
+// PartialSegment records how much of a segment body is in its .part file and
+// the validators needed to continue it with a Range request.
+type PartialSegment struct {
+	Offset       int64  `json:"offset"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// validator returns the value to send in If-Range, or "" if the partial body
+// cannot be safely continued. Weak ETags are not allowed in If-Range.
+func (s PartialSegment) validator() string {
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		return s.ETag
+	}
+	return s.LastModified
 }
 
 // CheckpointPath returns the checkpoint file path for an output file.
@@ -22,6 +68,32 @@ func CheckpointPath(outputPath string) string {
 	return outputPath + ".veld.json"
 }
 
+// TempDirFor returns the segment directory for a download inside base. It
+// depends only on the output path and URL, so a crashed run's files are
+// found again.
+func TempDirFor(base, outputPath, url string) string {
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		outputPath = abs
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\n%s", outputPath, url)
+	return filepath.Join(base, fmt.Sprintf("%s%016x", tempDirPrefix, h.Sum64()))
+}
+
+// isTempDir reports whether dir is a segment directory veld created
+// directly inside base.
+func isTempDir(base, dir string) bool {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return false
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	return filepath.Dir(dir) == base && strings.HasPrefix(filepath.Base(dir), tempDirPrefix)
+}
+
 // LoadCheckpoint loads a checkpoint from disk if it exists.
 func LoadCheckpoint(path string) (*Checkpoint, error) {
 	data, err := os.ReadFile(path)
@@ -39,13 +111,15 @@ func LoadCheckpoint(path string) (*Checkpoint, error) {
 	return &cp, nil
 }
 
-// NewCheckpoint creates a new checkpoint for a download.
-func NewCheckpoint(url, tempDir string) *Checkpoint {
+// NewCheckpoint creates a new checkpoint for a download of the given tracks.
+func NewCheckpoint(url, tempDir string, tracks []*models.Track) *Checkpoint {
 	return &Checkpoint{
-		URL:           url,
-		TempDir:       tempDir,
-		CompletedSegs: make(map[string][]int),
-		CreatedAt:     time.Now(),
+		URL:         url,
+		TempDir:     tempDir,
+		Tracks:      trackIDs(tracks),
+		Fingerprint: ManifestFingerprint(tracks),
+		Segments:    make(map[string]SegmentRecord),
+		CreatedAt:   time.Now(),
 	}
 }
 
@@ -58,6 +132,7 @@ func (c *Checkpoint) Save(path string) error {
 	if err != nil {
 		return err
 	}
+	c.dirty = false
 
 	// Write atomically via temp file
 	tempPath := path + ".tmp"
@@ -67,46 +142,227 @@ func (c *Checkpoint) Save(path string) error {
 	return os.Rename(tempPath, path)
 }
 
-// MarkDone marks a segment as completed.
-func (c *Checkpoint) MarkDone(trackID string, index int) {
+// AutoSave saves the checkpoint every interval while it has unsaved changes.
+// The returned function stops saving and writes a final save; it may be
+// called more than once.
+func (c *Checkpoint) AutoSave(path string, interval time.Duration) (stop func() error) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if c.isDirty() {
+					c.Save(path)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+	var err error
+	return func() error {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+			err = c.Save(path)
+		})
+		return err
+	}
+}
+
+func (c *Checkpoint) isDirty() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.CompletedSegs[trackID] = append(c.CompletedSegs[trackID], index)
+	return c.dirty
+}
+
+// Rebuild records segment files that exist in the temp dir but not in the
+// checkpoint, e.g. after a crash between saves. Segment files are only
+// created by an atomic rename, so any that exist are complete. It returns
+// the number of segments recovered.
+func (c *Checkpoint) Rebuild(tracks []*models.Track) int {
+	recovered := 0
+	for _, t := range tracks {
+		for _, seg := range t.Segments {
+			if c.IsSegmentDone(t.ID, seg.Index) {
+				continue
+			}
+			if rec, err := hashFile(c.SegmentPath(t.ID, seg.Index)); err == nil {
+				c.MarkDone(t.ID, seg.Index, rec)
+				recovered++
+			}
+		}
+	}
+	return recovered
+}
+
+// MarkDone records a completed segment with the size and hash of its file,
+// as computed while it was written.
+func (c *Checkpoint) MarkDone(trackID string, index int, rec SegmentRecord) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Segments == nil {
+		c.Segments = make(map[string]SegmentRecord)
+	}
+	c.Segments[segmentKey(trackID, index)] = rec
+	c.dirty = true
 }
 
 // IsSegmentDone checks if a segment has been downloaded.
 func (c *Checkpoint) IsSegmentDone(trackID string, index int) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	_, ok := c.Segments[segmentKey(trackID, index)]
+	return ok
+}
 
-	indices, ok := c.CompletedSegs[trackID]
+// Verify reports whether a completed segment's file still has the recorded
+// size and hash. Segments that fail are forgotten so they are downloaded again.
+func (c *Checkpoint) Verify(trackID string, index int) bool {
+	key := segmentKey(trackID, index)
+	c.mu.Lock()
+	want, ok := c.Segments[key]
+	c.mu.Unlock()
 	if !ok {
 		return false
 	}
-	for _, i := range indices {
-		if i == index {
-			return true
-		}
+
+	got, err := hashFile(c.SegmentPath(trackID, index))
+	if err == nil && got == want {
+		return true
 	}
+
+	c.mu.Lock()
+	delete(c.Segments, key)
+	c.dirty = true
+	c.mu.Unlock()
 	return false
 }
 
+// SetPartial records the state of a partially downloaded segment.
+func (c *Checkpoint) SetPartial(trackID string, index int, state PartialSegment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Partial == nil {
+		c.Partial = make(map[string]PartialSegment)
+	}
+	c.Partial[segmentKey(trackID, index)] = state
+	c.dirty = true
+}
+
+// GetPartial returns the recorded state of a partially downloaded segment.
+func (c *Checkpoint) GetPartial(trackID string, index int) (PartialSegment, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state, ok := c.Partial[segmentKey(trackID, index)]
+	return state, ok
+}
+
+// ClearPartial forgets a partially downloaded segment.
+func (c *Checkpoint) ClearPartial(trackID string, index int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.Partial, segmentKey(trackID, index))
+	c.dirty = true
+}
+
 // Delete removes the checkpoint file.
 func (c *Checkpoint) Delete(path string) error {
 	return os.Remove(path)
 }
 
-// CleanupTempDir removes the temp directory and its contents.
-func (c *Checkpoint) CleanupTempDir() error {
+// CleanupTempDir removes the temp directory and its contents. The path
+// comes from the checkpoint file, so only a veld segment directory inside
+// base is removed; anything else is refused.
+func (c *Checkpoint) CleanupTempDir(base string) error {
 	if c.TempDir == "" {
 		return nil
 	}
+	if !isTempDir(base, c.TempDir) {
+		return fmt.Errorf("not removing %s: not a veld temp dir in %s", c.TempDir, base)
+	}
 	return os.RemoveAll(c.TempDir)
 }
 
-// Matches checks if this checkpoint is for the same URL.
-func (c *Checkpoint) Matches(url string) bool {
-	return c.URL == url
+// Matches checks if this checkpoint is for the same URL, track selection
+// and segment layout.
+func (c *Checkpoint) Matches(url string, tracks []*models.Track) bool {
+	return c.URL == url &&
+		slices.Equal(c.Tracks, trackIDs(tracks)) &&
+		c.Fingerprint == ManifestFingerprint(tracks)
+}
+
+// ManifestFingerprint hashes the segment layout of the given tracks.
+// Query strings are ignored so that refreshed signed URLs still match.
+func ManifestFingerprint(tracks []*models.Track) string {
+	h := fnv.New64a()
+	writeSeg := func(seg *models.Segment) {
+		fmt.Fprintf(h, "%d|%s|%d", seg.Index, stripQuery(seg.URL), seg.Duration)
+		if seg.ByteRange != nil {
+			fmt.Fprintf(h, "|%d-%d", seg.ByteRange.Start, seg.ByteRange.End)
+		}
+		h.Write([]byte{'\n'})
+	}
+
+	for _, t := range tracks {
+		fmt.Fprintf(h, "track|%s|%s|%d\n", t.ID, t.Type, t.Bandwidth)
+		if t.InitSegment != nil {
+			writeSeg(t.InitSegment)
+		}
+		for _, seg := range t.Segments {
+			writeSeg(seg)
+		}
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+func stripQuery(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+func trackIDs(tracks []*models.Track) []string {
+	ids := make([]string, len(tracks))
+	for i, t := range tracks {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// hashFile returns the size and CRC-32C of a file. Only used for files
+// written by an earlier run; new segments are hashed as they are written.
+func hashFile(path string) (SegmentRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return SegmentRecord{}, err
+	}
+	defer f.Close()
+
+	h := crc32.New(crc32c)
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return SegmentRecord{}, err
+	}
+	return newSegmentRecord(n, h.Sum32()), nil
+}
+
+// newSegmentRecord returns the record of a size byte file with CRC-32C sum.
+func newSegmentRecord(size int64, sum uint32) SegmentRecord {
+	return SegmentRecord{Size: size, Hash: fmt.Sprintf("%08x", sum)}
 }
 
 // SegmentPath returns the expected path for a segment in the temp dir.
@@ -114,6 +370,10 @@ func (c *Checkpoint) SegmentPath(trackID string, index int) string {
 	return filepath.Join(c.TempDir, trackID+"_"+formatIndex(index)+".seg")
 }
 
+func segmentKey(trackID string, index int) string {
+	return fmt.Sprintf("%s/%d", trackID, index)
+}
+
 func formatIndex(i int) string {
-	return string(rune('0'+i/10000%10)) + string(rune('0'+i/1000%10)) + string(rune('0'+i/100%10)) + string(rune('0'+i/10%10)) + string(rune('0'+i%10))
+	return fmt.Sprintf("%05d", i)
 }
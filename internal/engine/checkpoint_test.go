@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func testTracks() []*models.Track {
+	return []*models.Track{
+		{ID: "v1", Type: models.TrackVideo, Segments: []*models.Segment{
+			{Index: 0, URL: "https://cdn.example.com/v1/0.ts?token=a", Duration: 6 * time.Second},
+			{Index: 1, URL: "https://cdn.example.com/v1/1.ts?token=a", Duration: 6 * time.Second},
+		}},
+		{ID: "a1", Type: models.TrackAudio},
+	}
+}
+
+func TestCheckpointMatches(t *testing.T) {
+	tracks := testTracks()
+	cp := NewCheckpoint("https://example.com/master.m3u8", t.TempDir(), tracks)
+
+	if !cp.Matches("https://example.com/master.m3u8", tracks) {
+		t.Error("same download does not match")
+	}
+
+	// Refreshed signed URLs keep the same layout
+	refreshed := testTracks()
+	refreshed[0].Segments[0].URL = "https://cdn.example.com/v1/0.ts?token=b"
+	if !cp.Matches("https://example.com/master.m3u8", refreshed) {
+		t.Error("refreshed query string should still match")
+	}
+
+	if cp.Matches("https://example.com/master.m3u8", tracks[:1]) {
+		t.Error("different track selection matched")
+	}
+
+	changed := testTracks()
+	changed[0].Segments = changed[0].Segments[:1]
+	if cp.Matches("https://example.com/master.m3u8", changed) {
+		t.Error("changed segment layout matched")
+	}
+}
+
+func TestCheckpointVerify(t *testing.T) {
+	dir := t.TempDir()
+	cp := NewCheckpoint("https://example.com/master.m3u8", dir, testTracks())
+
+	path := cp.SegmentPath("v1", 0)
+	if err := os.WriteFile(path, []byte("segment data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := hashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cp.MarkDone("v1", 0, rec)
+
+	// Survives a save/load round trip
+	cpPath := filepath.Join(dir, "out.veld.json")
+	if err := cp.Save(cpPath); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCheckpoint(cpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Verify("v1", 0) {
+		t.Fatal("intact segment failed verification")
+	}
+
+	// Same size, different content
+	if err := os.WriteFile(path, []byte("segment DATA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Verify("v1", 0) {
+		t.Error("corrupt segment passed verification")
+	}
+	if loaded.IsSegmentDone("v1", 0) {
+		t.Error("corrupt segment still marked done")
+	}
+
+	// Missing file
+	if err := os.WriteFile(path, []byte("segment data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cp.MarkDone("v1", 0, rec)
+	os.Remove(path)
+	if cp.Verify("v1", 0) {
+		t.Error("missing segment passed verification")
+	}
+}
+
+func TestCheckpointRebuild(t *testing.T) {
+	tracks := testTracks()
+	cp := NewCheckpoint("https://example.com/master.m3u8", t.TempDir(), tracks)
+
+	// Segment 1 finished after the last save; segment 0 never did
+	os.WriteFile(cp.SegmentPath("v1", 1), []byte("data"), 0644)
+	os.WriteFile(cp.SegmentPath("v1", 0)+".part", []byte("da"), 0644)
+
+	if n := cp.Rebuild(tracks); n != 1 {
+		t.Fatalf("recovered %d segments, want 1", n)
+	}
+	if cp.IsSegmentDone("v1", 0) || !cp.Verify("v1", 1) {
+		t.Error("wrong segments recovered")
+	}
+}
+
+func TestTempDirFor(t *testing.T) {
+	a := TempDirFor("/tmp", "out/video.mp4", "https://example.com/a.m3u8")
+	if a != TempDirFor("/tmp", "out/video.mp4", "https://example.com/a.m3u8") {
+		t.Error("temp dir is not stable")
+	}
+	if a == TempDirFor("/tmp", "out/video.mp4", "https://example.com/b.m3u8") {
+		t.Error("different URLs share a temp dir")
+	}
+	if filepath.Dir(TempDirFor("/scratch", "out/video.mp4", "https://example.com/a.m3u8")) != "/scratch" {
+		t.Error("temp dir is not inside the base dir")
+	}
+}
+
+func TestCleanupTempDirStaysInBase(t *testing.T) {
+	base := t.TempDir()
+	victim := t.TempDir()
+	for _, dir := range []string{
+		victim,                              // edited checkpoint pointing elsewhere
+		filepath.Join(base, "data"),         // not a veld dir
+		filepath.Join(base, "veld_x", ".."), // the base itself
+	} {
+		os.MkdirAll(dir, 0755)
+		cp := &Checkpoint{TempDir: dir}
+		if err := cp.CleanupTempDir(base); err == nil {
+			t.Errorf("removed %s", dir)
+		}
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("%s is gone: %v", dir, err)
+		}
+	}
+
+	own := TempDirFor(base, "out.mp4", "https://example.com/a.m3u8")
+	os.MkdirAll(own, 0755)
+	if err := (&Checkpoint{TempDir: own}).CleanupTempDir(base); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(own); !os.IsNotExist(err) {
+		t.Errorf("own temp dir not removed: %v", err)
+	}
+}
+
+func TestSegmentPathWidensIndex(t *testing.T) {
+	cp := &Checkpoint{TempDir: "/tmp/veld_x"}
+	if a, b := cp.SegmentPath("v", 0), cp.SegmentPath("v", 100000); a == b {
+		t.Errorf("segments 0 and 100000 share %s", a)
+	}
+	if got := filepath.Base(cp.SegmentPath("v", 123456)); got != "v_123456.seg" {
+		t.Errorf("segment 123456 file = %s", got)
+	}
+	if got := filepath.Base(cp.SegmentPath("v", 7)); got != "v_00007.seg" {
+		t.Errorf("segment 7 file = %s", got)
+	}
+}
+
+func TestCheckpointAutoSave(t *testing.T) {
+	dir := t.TempDir()
+	cpPath := filepath.Join(dir, "out.veld.json")
+	cp := NewCheckpoint("https://example.com/master.m3u8", dir, testTracks())
+
+	stop := cp.AutoSave(cpPath, 10*time.Millisecond)
+	cp.MarkDone("v1", 0, SegmentRecord{Size: 4})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		loaded, _ := LoadCheckpoint(cpPath)
+		if loaded != nil && loaded.IsSegmentDone("v1", 0) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("checkpoint was not saved in the background")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stop(); err != nil {
+		t.Fatal(err)
+	}
+}
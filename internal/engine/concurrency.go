@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+)
+
+// adaptInterval is how often the adaptive controller re-tunes concurrency.
+const adaptInterval = 2 * time.Second
+
+// concurrencyController limits how many workers download at once and tunes
+// the limit with AIMD: it adds one slot per interval while throughput keeps
+// up, halves the limit when the server throttles (429/503, timeouts), and
+// backs off by a quarter when latency climbs well above the best seen.
+type concurrencyController struct {
+	min, max int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+	closed bool
+
+	// Observations since the last adjustment
+	bytes      int64
+	successes  int
+	throttled  int
+	latency    time.Duration // Sum of time to first byte
+	requests   int
+	lastAdjust time.Time
+
+	baseline       time.Duration // Lowest average latency seen
+	lastThroughput float64       // Bytes per second in the previous interval
+}
+
+func newConcurrencyController(min, max int) *concurrencyController {
+	min = clampInt(min, 1, max)
+	c := &concurrencyController{
+		min:        min,
+		max:        max,
+		limit:      clampInt(max/2, min, max),
+		lastAdjust: time.Now(),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Limit returns the current number of concurrent downloads allowed.
+func (c *concurrencyController) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// acquire blocks until a download slot is free. It returns false once the
+// controller is closed.
+func (c *concurrencyController) acquire() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.active >= c.limit && !c.closed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return false
+	}
+	c.active++
+	return true
+}
+
+// release frees a slot taken by acquire.
+func (c *concurrencyController) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	c.cond.Signal()
+}
+
+// close wakes all waiting workers; acquire fails from then on.
+func (c *concurrencyController) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	c.cond.Broadcast()
+}
+
+// observeLatency records the time to first byte of a request.
+func (c *concurrencyController) observeLatency(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency += d
+	c.requests++
+}
+
+// observe records the outcome of a download attempt.
+func (c *concurrencyController) observe(bytes int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case err == nil:
+		c.bytes += bytes
+		c.successes++
+	case isThrottled(err):
+		c.throttled++
+	}
+}
+
+// adjust re-tunes the limit from the observations since the last call. It
+// returns the old limit and the reason for a change ("" if unchanged).
+func (c *concurrencyController) adjust(now time.Time) (old int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	old = c.limit
+	elapsed := now.Sub(c.lastAdjust)
+	c.lastAdjust = now
+	if c.successes+c.throttled == 0 || elapsed <= 0 {
+		return old, "" // Idle or waiting on retries
+	}
+
+	throughput := float64(c.bytes) / elapsed.Seconds()
+	var avgLatency time.Duration
+	if c.requests > 0 {
+		avgLatency = c.latency / time.Duration(c.requests)
+	}
+
+	switch {
+	case c.throttled > 0:
+		c.limit = max(c.min, c.limit/2)
+		reason = "throttled"
+	case c.baseline > 0 && avgLatency > 2*c.baseline:
+		c.limit = max(c.min, c.limit-max(1, c.limit/4))
+		reason = "latency rising"
+	case c.limit < c.max && throughput >= 0.95*c.lastThroughput:
+		c.limit++
+		reason = "throughput"
+	}
+
+	if avgLatency > 0 && (c.baseline == 0 || avgLatency < c.baseline) {
+		c.baseline = avgLatency
+	}
+	c.lastThroughput = throughput
+	c.bytes, c.successes, c.throttled = 0, 0, 0
+	c.latency, c.requests = 0, 0
+
+	if c.limit == old {
+		reason = ""
+	}
+	c.cond.Broadcast()
+	return old, reason
+}
+
+// isThrottled reports whether an error suggests the server or network is
+// overloaded, as opposed to a missing or broken segment.
+func isThrottled(err error) bool {
+	var se *httpclient.StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusTooManyRequests || se.StatusCode == http.StatusServiceUnavailable
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
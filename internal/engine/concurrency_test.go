@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+)
+
+func TestConcurrencyControllerAIMD(t *testing.T) {
+	c := newConcurrencyController(2, 16)
+	if got := c.Limit(); got != 8 {
+		t.Fatalf("initial limit = %d, want 8", got)
+	}
+
+	now := c.lastAdjust
+	step := func(bytes int64, latency time.Duration, err error) (int, string) {
+		c.observeLatency(latency)
+		c.observe(bytes, err)
+		now = now.Add(adaptInterval)
+		_, reason := c.adjust(now)
+		return c.Limit(), reason
+	}
+
+	// Steady throughput: additive increase
+	if limit, reason := step(1<<20, 50*time.Millisecond, nil); limit != 9 || reason != "throughput" {
+		t.Errorf("after success: limit %d (%s), want 9 (throughput)", limit, reason)
+	}
+	if limit, _ := step(1<<20, 50*time.Millisecond, nil); limit != 10 {
+		t.Errorf("after second success: limit %d, want 10", limit)
+	}
+
+	// Throttling: multiplicative decrease
+	if limit, reason := step(0, 50*time.Millisecond, &httpclient.StatusError{StatusCode: 429}); limit != 5 || reason != "throttled" {
+		t.Errorf("after 429: limit %d (%s), want 5 (throttled)", limit, reason)
+	}
+
+	// Latency well above the baseline: back off by a quarter
+	if limit, reason := step(1<<20, 200*time.Millisecond, nil); limit != 4 || reason != "latency rising" {
+		t.Errorf("after slow responses: limit %d (%s), want 4 (latency rising)", limit, reason)
+	}
+
+	// A 404 is not a throttling signal
+	c.observe(0, &httpclient.StatusError{StatusCode: 404})
+	now = now.Add(adaptInterval)
+	if _, reason := c.adjust(now); reason != "" {
+		t.Errorf("404 only: limit changed (%s)", reason)
+	}
+
+	// Never below the minimum
+	for range 5 {
+		step(0, 0, &httpclient.StatusError{StatusCode: 503})
+	}
+	if got := c.Limit(); got != 2 {
+		t.Errorf("limit after repeated throttling = %d, want 2", got)
+	}
+}
+
+func TestConcurrencyControllerSlots(t *testing.T) {
+	c := newConcurrencyController(1, 2)
+	if !c.acquire() {
+		t.Fatal("acquire failed")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- c.acquire() }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired more slots than the limit")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	c.release()
+	if !<-acquired {
+		t.Fatal("waiting worker did not get the released slot")
+	}
+
+	go func() { acquired <- c.acquire() }()
+	c.close()
+	if <-acquired {
+		t.Error("acquire succeeded after close")
+	}
+}
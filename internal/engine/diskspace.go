@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// ErrDiskSpace is returned when a download needs more disk space than is
+// free, before it starts or when free space runs low while it runs.
+var ErrDiskSpace = errors.New("not enough disk space")
+
+const (
+	probeSegments = 3 // HEAD requests per track when nothing else gives its size
+	probeTimeout  = 10 * time.Second
+)
+
+// diskWatchInterval is how often free space is checked while downloading.
+var diskWatchInterval = 2 * time.Second
+
+// spaceNeed is an estimate of the bytes a download will write to dir.
+type spaceNeed struct {
+	dir   string
+	bytes int64
+}
+
+// checkDiskSpace returns an ErrDiskSpace error if any file system lacks
+// room for the needs on it plus reserve. Needs on the same file system add
+// up, e.g. segments in the temp dir and the output next to them. Nothing
+// is checked where free space can't be read.
+func checkDiskSpace(needs []spaceNeed, reserve int64) error {
+	type volume struct {
+		dir        string
+		free, need int64
+	}
+	var volumes []*volume
+	byID := make(map[string]*volume)
+	for _, n := range needs {
+		free, id, err := diskUsage(existingParent(n.dir))
+		if errors.Is(err, errors.ErrUnsupported) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("check disk space in %s: %w", n.dir, err)
+		}
+		v := byID[id]
+		if v == nil {
+			v = &volume{dir: n.dir, free: free}
+			byID[id] = v
+			volumes = append(volumes, v)
+		}
+		v.need += n.bytes
+	}
+
+	for _, v := range volumes {
+		if v.need+reserve > v.free {
+			return fmt.Errorf("%w in %s: need about %s plus %s reserve, %s free",
+				ErrDiskSpace, v.dir, formatSize(v.need), formatSize(reserve), formatSize(v.free))
+		}
+	}
+	return nil
+}
+
+// existingParent returns dir, or its nearest parent that exists.
+func existingParent(dir string) string {
+	dir, _ = filepath.Abs(dir)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// watchDiskSpace checks the free space under dirs every diskWatchInterval
+// and calls stop with an ErrDiskSpace error once it falls below reserve,
+// so the download ends with its progress saved instead of failing
+// segments on a full disk. The returned function ends the watch.
+func watchDiskSpace(dirs []string, reserve int64, stop context.CancelCauseFunc) func() {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(diskWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			for _, dir := range dirs {
+				free, _, err := diskUsage(existingParent(dir))
+				if err != nil || free >= reserve {
+					continue
+				}
+				stop(fmt.Errorf("%w: %s free in %s, below the %s reserve; progress saved, free up space and run again to resume",
+					ErrDiskSpace, formatSize(free), dir, formatSize(reserve)))
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		wg.Wait()
+	}
+}
+
+// diskSpaceNeeds estimates what the download still has to write: pending
+// segments in tempDir, the tracks the muxer copies to muxDir, and the
+// output. Tracks whose size can't be estimated are left out.
+func (e *Engine) diskSpaceNeeds(ctx context.Context, tempDir, muxDir, outputDir string) []spaceNeed {
+	var pending, total int64
+	for _, track := range e.SelectedTracks {
+		avg, ok := e.segmentSize(ctx, track)
+		if !ok {
+			e.log.DebugContext(ctx, "disk space: track size unknown, not counted", "track", track.ID)
+			continue
+		}
+		for _, seg := range track.Segments {
+			size := avg
+			if r := seg.ByteRange; r != nil && r.End >= r.Start {
+				size = r.End - r.Start + 1
+			}
+			total += size
+			if !e.checkpoint.IsSegmentDone(track.ID, seg.Index) {
+				pending += size
+			}
+		}
+	}
+	e.log.DebugContext(ctx, "disk space estimate", "download", formatSize(pending), "total", formatSize(total))
+
+	// The pipeline deletes segments once written and never copies tracks;
+	// a stream has no output file
+	switch {
+	case e.cfg.Writer != nil:
+		return []spaceNeed{{tempDir, pending}}
+	case e.cfg.Pipeline:
+		return []spaceNeed{{outputDir, total}}
+	default:
+		return []spaceNeed{{tempDir, pending}, {muxDir, total}, {outputDir, total}}
+	}
+}
+
+// segmentSize estimates a track's average segment size from its byte
+// ranges, its bandwidth and segment durations, or HEAD requests for a few
+// segments, in that order.
+func (e *Engine) segmentSize(ctx context.Context, track *models.Track) (int64, bool) {
+	if len(track.Segments) == 0 {
+		return 0, true
+	}
+
+	var ranged, rangedBytes int64
+	var duration time.Duration
+	for _, seg := range track.Segments {
+		if r := seg.ByteRange; r != nil && r.End >= r.Start {
+			ranged++
+			rangedBytes += r.End - r.Start + 1
+		}
+		duration += seg.Duration
+	}
+	if ranged == int64(len(track.Segments)) {
+		return rangedBytes / ranged, true
+	}
+	if track.Bandwidth > 0 && duration > 0 {
+		return int64(float64(track.Bandwidth) / 8 * duration.Seconds() / float64(len(track.Segments))), true
+	}
+
+	// Probe segments spread over the track
+	var probed, probedBytes int64
+	n := min(probeSegments, len(track.Segments))
+	for i := range n {
+		seg := track.Segments[i*(len(track.Segments)-1)/max(n-1, 1)]
+		if size := e.contentLength(ctx, seg.URL); size > 0 {
+			probed++
+			probedBytes += size
+		}
+	}
+	if probed == 0 {
+		return 0, false
+	}
+	return probedBytes / probed, true
+}
+
+// contentLength returns the Content-Length of a HEAD request for url, or
+// -1 if the server doesn't tell. It goes through the segment client, so
+// the segment proxy and bandwidth settings apply as for the download.
+func (e *Engine) contentLength(ctx context.Context, url string) int64 {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return -1
+	}
+	resp, err := e.pool.client.Do(req)
+	if err != nil {
+		return -1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return -1
+	}
+	return resp.ContentLength
+}
+
+func formatSize(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "KMGTPE"[exp])
+}
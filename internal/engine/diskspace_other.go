@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package engine
+
+import "errors"
+
+// diskUsage is not implemented here; disk space isn't checked.
+func diskUsage(path string) (int64, string, error) {
+	return 0, "", errors.ErrUnsupported
+}
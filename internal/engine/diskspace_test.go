@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/config"
+	"github.com/mohaanymo/veld/internal/httpclient"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	free, _, err := diskUsage(dir)
+	if err != nil {
+		t.Skipf("free space unavailable: %v", err)
+	}
+
+	// The output dir doesn't exist yet; it's checked through its parent
+	output := filepath.Join(dir, "out", "sub")
+	if err := checkDiskSpace([]spaceNeed{{dir, 1 << 20}, {output, 1 << 20}}, 0); err != nil {
+		t.Errorf("2 MB reported as not fitting: %v", err)
+	}
+
+	// Needs on one file system add up
+	half := free/2 + 1
+	err = checkDiskSpace([]spaceNeed{{dir, half}, {output, half}}, 0)
+	if !errors.Is(err, ErrDiskSpace) {
+		t.Errorf("two halves of the free space fit: %v", err)
+	}
+	if err := checkDiskSpace([]spaceNeed{{dir, 0}}, free+1<<30); !errors.Is(err, ErrDiskSpace) {
+		t.Errorf("reserve above the free space accepted: %v", err)
+	}
+}
+
+func TestWatchDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := diskUsage(dir); err != nil {
+		t.Skipf("free space unavailable: %v", err)
+	}
+	defer func(d time.Duration) { diskWatchInterval = d }(diskWatchInterval)
+	diskWatchInterval = 10 * time.Millisecond
+
+	ctx, stop := context.WithCancelCause(context.Background())
+	stopWatch := watchDiskSpace([]string{dir}, 1<<62, stop)
+	defer stopWatch()
+
+	select {
+	case <-ctx.Done():
+		if !errors.Is(context.Cause(ctx), ErrDiskSpace) {
+			t.Errorf("stopped with %v", context.Cause(ctx))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("low disk space not detected")
+	}
+}
+
+func TestSegmentSize(t *testing.T) {
+	var heads atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads.Add(1)
+		}
+		w.Header().Set("Content-Length", "1000")
+	}))
+	defer srv.Close()
+
+	// Probes go through the segment client, as the download will
+	manifestClient := &http.Client{Transport: httpclient.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+		t.Error("size probe sent through the manifest client")
+		return nil, errors.New("wrong client")
+	})}
+	e := &Engine{cfg: config.New(), log: slog.New(slog.DiscardHandler), client: manifestClient,
+		pool: NewWorkerPool(1, srv.Client(), nil)}
+	segments := func(n int, d time.Duration) []*models.Segment {
+		var segs []*models.Segment
+		for i := range n {
+			segs = append(segs, &models.Segment{Index: i, URL: srv.URL, Duration: d})
+		}
+		return segs
+	}
+
+	ranged := &models.Track{Segments: []*models.Segment{
+		{ByteRange: &models.ByteRange{Start: 0, End: 99}},
+		{ByteRange: &models.ByteRange{Start: 100, End: 399}},
+	}}
+	withBandwidth := &models.Track{Bandwidth: 800_000, Segments: segments(10, 4*time.Second)}
+	probed := &models.Track{Segments: segments(10, 0)}
+
+	for _, tc := range []struct {
+		name  string
+		track *models.Track
+		want  int64
+	}{
+		{"byte ranges", ranged, 200},
+		{"bandwidth", withBandwidth, 400_000},
+		{"HEAD probes", probed, 1000},
+	} {
+		if got, ok := e.segmentSize(context.Background(), tc.track); !ok || got != tc.want {
+			t.Errorf("%s: size = %d, %v; want %d", tc.name, got, ok, tc.want)
+		}
+	}
+	if n := heads.Load(); n != probeSegments {
+		t.Errorf("%d HEAD requests, want %d", n, probeSegments)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux || darwin || freebsd
+
+package engine
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// diskUsage returns the bytes available to unprivileged users on the file
+// system holding path, and an ID of that file system.
+func diskUsage(path string) (int64, string, error) {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(path, &fs); err != nil {
+		return 0, "", err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, "", err
+	}
+	return int64(fs.Bavail) * int64(fs.Bsize), strconv.FormatUint(uint64(st.Dev), 10), nil
+}
@@ -0,0 +1,24 @@
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var getDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskUsage returns the bytes available to the user on the volume holding
+// path, and the volume name.
+func diskUsage(path string) (int64, string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, "", err
+	}
+	var avail uint64
+	if r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0); r == 0 {
+		return 0, "", err
+	}
+	return int64(avail), strings.ToUpper(filepath.VolumeName(path)), nil
+}
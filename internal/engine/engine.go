@@ -3,11 +3,15 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/mohaanymo/veld/internal/config"
@@ -20,12 +24,18 @@ import (
 // Engine is the main download orchestrator.
 type Engine struct {
 	cfg        *config.Config
+	log        *slog.Logger
 	client     *http.Client
+	jar        *httpclient.CookieJar   // nil without a cookie file
+	bandwidth  *httpclient.BudgetShare // nil without a shared budget
+	schedule   *httpclient.Budget      // nil without a bandwidth schedule
+	retry      httpclient.RetryPolicy
 	pool       *WorkerPool
 	progressCh chan ProgressUpdate
 
 	// Selected tracks (set after selection)
 	SelectedTracks []*models.Track
+	prepared       bool // segments loaded and clipped
 
 	// Resume support
 	checkpoint     *Checkpoint
@@ -33,33 +43,193 @@ type Engine struct {
 
 	// Pluggable interfaces
 	muxer Muxer
+	sched Scheduler // nil = chosen from the config
+
+	hlsDec *decryptor.HLSDecryptor // shared AES-128 key cache
 }
 
 // New creates a new Engine with optimized settings.
 func New(cfg *config.Config) (*Engine, error) {
-	// Use shared HTTP client with optional rate limiting
-	var client *http.Client
-	if cfg.MaxBandwidth > 0 {
-		client = httpclient.NewWithRateLimit(httpclient.DefaultConfig(), cfg.MaxBandwidth)
+	return NewShared(cfg, Shared{})
+}
+
+// Shared holds limits that several engines draw from, e.g. all tasks of
+// a Manager. Nil fields are not shared.
+type Shared struct {
+	Budget *httpclient.Budget      // Global bandwidth, split by cfg.BandwidthWeight
+	Hosts  *httpclient.HostLimiter // Per-host state of cfg.HostLimits
+}
+
+// NewShared is like New, but segment downloads also take a share of
+// shared.Budget, and cfg.HostLimits count requests of every engine using
+// shared.Hosts.
+func NewShared(cfg *config.Config, shared Shared) (*Engine, error) {
+	// Manifests, keys and init segments go through cfg.Proxy; bulk segment
+	// traffic through cfg.SegmentProxy (if set), with optional rate limiting
+	for _, proxy := range []string{cfg.Proxy, cfg.SegmentProxy} {
+		if _, err := httpclient.ParseProxy(proxy); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig, err := httpclient.NewTLSConfig(httpclient.TLSOptions{
+		CAFile:   cfg.CAFile,
+		CertFile: cfg.ClientCert,
+		KeyFile:  cfg.ClientKey,
+		Insecure: cfg.Insecure,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resolve := make(map[string]string, len(cfg.Resolve))
+	for _, r := range cfg.Resolve {
+		hostPort, addr, err := httpclient.ParseResolve(r)
+		if err != nil {
+			return nil, err
+		}
+		resolve[hostPort] = addr
+	}
+	// Name overrides only apply to the manifest's host, so segments or keys
+	// on other hosts (e.g. a CDN) keep their own SNI and Host header
+	var overrides map[string]httpclient.HostOverride
+	if cfg.TLSServerName != "" || cfg.HostHeader != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("TLS server name or Host override needs a manifest URL with a host")
+		}
+		overrides = map[string]httpclient.HostOverride{
+			httpclient.HostPort(u): {ServerName: cfg.TLSServerName, Host: cfg.HostHeader},
+		}
+	}
+
+	// Host limits are shared by both clients, and with other engines using
+	// shared.Hosts, so a host's budget covers all requests to it
+	var policies []httpclient.HostPolicy
+	for _, h := range cfg.HostLimits {
+		p, err := httpclient.ParseHostPolicy(h)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	var hostLimits httpclient.Middleware
+	if len(policies) > 0 {
+		hosts := shared.Hosts
+		if hosts == nil {
+			hosts = httpclient.NewHostLimiter()
+		}
+		hostLimits = hosts.Middleware(policies)
+	}
+	var windows []httpclient.BandwidthWindow
+	if cfg.BandwidthSchedule != "" {
+		if windows, err = httpclient.ParseBandwidthSchedule(cfg.BandwidthSchedule); err != nil {
+			return nil, err
+		}
+	}
+
+	// One cookie jar for all requests, so Set-Cookie from any response
+	// (e.g. an auth gateway renewing a session) applies to the rest
+	var jar *httpclient.CookieJar
+	if cfg.CookieFile != "" {
+		jar = httpclient.NewCookieJar()
+		if err := jar.Load(cfg.CookieFile); err != nil {
+			return nil, fmt.Errorf("load cookies: %w", err)
+		}
+	}
+
+	log := cfg.Log()
+	clientCfg := httpClientConfig(cfg, jar, hostLimits, log)
+	clientCfg.TLS = tlsConfig
+	clientCfg.Resolve = resolve
+	clientCfg.Overrides = overrides
+	clientCfg.Timeout = cfg.Timeout
+	clientCfg.Proxy = cfg.Proxy
+	client := httpclient.New(clientCfg)
+
+	segmentCfg := httpClientConfig(cfg, jar, hostLimits, log)
+	segmentCfg.TLS = tlsConfig
+	segmentCfg.Resolve = resolve
+	segmentCfg.Overrides = overrides
+	segmentCfg.Proxy = cfg.Proxy
+	// Segments may take longer than Timeout to download; only a stall fails them
+	segmentCfg.StallTimeout = cfg.Timeout
+	if cfg.SegmentProxy != "" {
+		segmentCfg.Proxy = cfg.SegmentProxy
+	}
+	// A bandwidth schedule needs a limiter that changes over time, so it
+	// gets a budget of its own
+	var schedule *httpclient.Budget
+	if len(windows) > 0 {
+		schedule = httpclient.NewBudget(cfg.MaxBandwidth)
+		schedule.SetSchedule(windows)
+		segmentCfg.Middleware = append(segmentCfg.Middleware, schedule.Share(1).Middleware())
 	} else {
-		client = httpclient.New(httpclient.DefaultConfig())
+		segmentCfg.MaxBandwidth = cfg.MaxBandwidth
+	}
+	var bandwidth *httpclient.BudgetShare
+	if shared.Budget != nil {
+		bandwidth = shared.Budget.Share(cfg.BandwidthWeight)
+		segmentCfg.Middleware = append(segmentCfg.Middleware, bandwidth.Middleware())
 	}
+	segmentClient := httpclient.New(segmentCfg)
 
 	progressCh := make(chan ProgressUpdate, 100)
 
 	e := &Engine{
 		cfg:        cfg,
+		log:        log,
 		client:     client,
+		jar:        jar,
+		bandwidth:  bandwidth,
+		schedule:   schedule,
+		retry:      httpclient.NewRetryPolicy(cfg.RetryAttempts, cfg.RetryDelay, cfg.RetryMaxTime),
 		progressCh: progressCh,
 		muxer:      NewAutoMuxer(cfg),
 	}
 
-	e.pool = NewWorkerPool(cfg.Threads, client, progressCh)
-	e.pool.SetVerbose(cfg.Verbose)
+	e.pool = NewWorkerPool(cfg.Threads, segmentClient, progressCh)
+	e.pool.SetLogger(log)
+	e.pool.SetRetryPolicy(e.retry)
+	e.pool.SetSegmentCheck(!cfg.NoSegmentCheck)
+	if cfg.AdaptiveThreads {
+		e.pool.SetAdaptive(cfg.AdaptiveMinThreads)
+	}
+	if cfg.RefreshAfter > 0 {
+		e.pool.SetURLRefresh(cfg.RefreshAfter, e.refreshSegmentURLs)
+	}
 
 	return e, nil
 }
 
+// httpClientConfig returns the client settings shared by manifest and
+// segment requests: headers, cookies, user agent, cookie jar, host limits
+// and request logging, on top of cfg.HTTPClient if one was provided.
+func httpClientConfig(cfg *config.Config, jar *httpclient.CookieJar, hostLimits httpclient.Middleware, log *slog.Logger) httpclient.Config {
+	c := httpclient.DefaultConfig()
+	c.Logger = log
+	c.Base = cfg.HTTPClient
+	c.Headers = cfg.Headers
+	c.Cookies = cfg.Cookies
+	if jar != nil {
+		c.Jar = jar
+	}
+	if hostLimits != nil {
+		c.Middleware = append(c.Middleware, hostLimits)
+	}
+	c.UserAgent = cfg.UserAgent
+	return c
+}
+
+// ParseManifest fetches and parses the manifest at url through the
+// engine's manifest client and retry policy.
+func (e *Engine) ParseManifest(ctx context.Context, url string) (*models.Manifest, error) {
+	registry := parser.NewRegistry()
+	registry.SetClient(e.client)
+	registry.SetRetryPolicy(e.retry)
+	registry.SetLogger(e.log)
+	return registry.Parse(ctx, url, nil)
+}
+
 // SelectTracks selects tracks from manifest and stores them.
 func (e *Engine) SelectTracks(manifest *models.Manifest) error {
 	selected, err := SelectTracks(manifest.Tracks, e.cfg.TrackSelector)
@@ -79,36 +249,83 @@ func (e *Engine) SelectTracks(manifest *models.Manifest) error {
 			}
 		}
 
-		// HLS AES-128 decryptor - key fetched from URI
+		// HLS AES-128 decryptor - key fetched from URI, cache shared across tracks
 		if track.EncryptionURI != "" && track.Decryptor == nil {
-			track.HLSDecryptor = decryptor.NewHLSDecryptor(e.client, e.cfg.Headers)
+			track.HLSDecryptor = e.hlsDecryptor()
 		}
 	}
 	e.SelectedTracks = selected
 	return nil
 }
 
-// Download initiates the download process for selected tracks.
-func (e *Engine) Download(ctx context.Context, manifest *models.Manifest) error {
-	if e.SelectedTracks == nil {
-		if err := e.SelectTracks(manifest); err != nil {
-			return err
-		}
+// PrepareTracks lazy loads segments for the selected tracks, applies the
+// configured time range and drops ad breaks if requested.
+// It is safe to call more than once.
+func (e *Engine) PrepareTracks(ctx context.Context, manifest *models.Manifest) error {
+	if e.prepared {
+		return nil
 	}
 
-	// Lazy load segments for tracks with media playlist URL but no segments
 	for _, track := range e.SelectedTracks {
-		if e.cfg.Verbose {
-			fmt.Printf("Track %s: Type=%s, MediaPlaylistURL=%q, Segments=%d\n",
-				track.ID, track.Type, track.MediaPlaylistURL, len(track.Segments))
-		}
+		e.log.DebugContext(ctx, "preparing track", "track", track.ID, "type", track.Type.String(),
+			"url", httpclient.RedactURL(track.MediaPlaylistURL), "segments", len(track.Segments))
+		// Lazy load segments for tracks with media playlist URL but no segments
 		if track.MediaPlaylistURL != "" && len(track.Segments) == 0 {
 			if err := e.LoadTrackSegments(ctx, track); err != nil {
 				return fmt.Errorf("load segments for %s: %w", track.ID, err)
 			}
 		}
+		assignStartTimes(track.Segments)
+		if err := e.clipTrack(track); err != nil {
+			return err
+		}
+		e.skipAds(track, manifest)
+	}
+
+	e.prepared = true
+	return nil
+}
+
+// hlsDecryptor returns the engine's shared HLS decryptor.
+func (e *Engine) hlsDecryptor() *decryptor.HLSDecryptor {
+	if e.hlsDec == nil {
+		e.hlsDec = decryptor.NewHLSDecryptor(e.client, nil)
+		e.hlsDec.SetRetryPolicy(e.retry)
+		e.hlsDec.SetLogger(e.log)
+	}
+	return e.hlsDec
+}
+
+// prefetchSessionKeys warms the HLS key cache from EXT-X-SESSION-KEY tags.
+// Failures are not fatal: keys are fetched again on first use.
+func (e *Engine) prefetchSessionKeys(ctx context.Context, manifest *models.Manifest) {
+	if len(manifest.SessionKeys) == 0 || e.hlsDec == nil {
+		return // no selected track uses AES-128
+	}
+	if err := e.hlsDecryptor().PrefetchKeys(ctx, manifest.SessionKeys); err != nil {
+		e.log.WarnContext(ctx, "session key prefetch failed", "error", err)
+	}
+}
+
+// Download initiates the download process for selected tracks.
+func (e *Engine) Download(ctx context.Context, manifest *models.Manifest) error {
+	if e.SelectedTracks == nil {
+		if err := e.SelectTracks(manifest); err != nil {
+			return err
+		}
+	}
+
+	if err := e.PrepareTracks(ctx, manifest); err != nil {
+		return err
 	}
 
+	sched, err := e.scheduler()
+	if err != nil {
+		return err
+	}
+
+	e.prefetchSessionKeys(ctx, manifest)
+
 	// Download init segments first (required for fMP4)
 	for _, track := range e.SelectedTracks {
 		if track.InitSegment != nil && track.InitSegment.URL != "" {
@@ -120,31 +337,66 @@ func (e *Engine) Download(ctx context.Context, manifest *models.Manifest) error
 
 	// Set up temp directory and checkpoint for resume support
 	outputPath := filepath.Join(e.cfg.OutputDir, e.cfg.FileName)
-	e.checkpointPath = CheckpointPath(outputPath)
-	tempDir := filepath.Join(os.TempDir(), fmt.Sprintf("veld_%d", os.Getpid()))
+	tempDir, stopAutoSave, err := e.setupCheckpoint(outputPath)
+	if err != nil {
+		return err
+	}
+	defer stopAutoSave()
+	if e.cfg.Writer != nil {
+		defer os.RemoveAll(tempDir)
+	}
 
-	// Try to load existing checkpoint for resume
-	existingCP, _ := LoadCheckpoint(e.checkpointPath)
-	if existingCP != nil && existingCP.Matches(e.cfg.URL) {
-		// Resume from existing checkpoint
-		tempDir = existingCP.TempDir
-		e.checkpoint = existingCP
-		if e.cfg.Verbose {
-			fmt.Printf("Resuming download from checkpoint\n")
+	e.pool.SetTempDir(tempDir)
+	e.pool.SetCheckpoint(e.checkpoint)
+
+	// Check for room before starting, then watch free space so a full disk
+	// stops the download with its progress saved
+	ctx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+	stopWatch := func() {}
+	if !e.cfg.NoSpaceCheck {
+		needs := e.diskSpaceNeeds(ctx, tempDir, e.cfg.TempBase(), filepath.Dir(outputPath))
+		if err := checkDiskSpace(needs, e.cfg.MinFreeSpace); err != nil {
+			return err
 		}
-	} else {
-		// Create new checkpoint
-		if err := os.MkdirAll(tempDir, 0755); err != nil {
-			return fmt.Errorf("create temp dir: %w", err)
+		watchDirs := []string{tempDir}
+		if e.cfg.Writer == nil {
+			watchDirs = append(watchDirs, filepath.Dir(outputPath))
 		}
-		e.checkpoint = NewCheckpoint(e.cfg.URL, tempDir)
+		stopWatch = watchDiskSpace(watchDirs, e.cfg.MinFreeSpace, stop)
 	}
+	defer stopWatch()
 
-	e.pool.SetTempDir(tempDir)
+	format := ContainerFormat(e.cfg.Format)
+	if e.cfg.ExportEvents && len(manifest.Events) > 0 {
+		if cm, ok := e.muxer.(ChapterMuxer); ok {
+			cm.SetChapters(buildChapters(manifest.Events, e.SelectedTracks))
+		}
+	}
+
+	// Pipeline mode: mux media tracks while they download. Writer output
+	// is always streamed, in order, as segments finish.
+	var stream *MuxStream
+	if e.cfg.Pipeline || e.cfg.Writer != nil {
+		if stream, err = e.startMuxStream(ctx, outputPath, format); err != nil {
+			return err
+		}
+		if stream == nil && e.cfg.Writer != nil {
+			return fmt.Errorf("this muxer cannot write to a stream")
+		}
+	}
 
 	// Set up checkpoint callback
-	e.pool.SetOnSegmentDone(func(trackID string, index int) {
-		e.checkpoint.MarkDone(trackID, index)
+	e.pool.SetOnSegmentDone(func(trackID string, index int, rec SegmentRecord) {
+		e.checkpoint.MarkDone(trackID, index, rec)
+		if stream != nil {
+			stream.Done(trackID, index)
+		}
+	})
+	e.pool.SetOnSegmentFailed(func(trackID string, index int) {
+		if stream != nil {
+			stream.Failed(trackID, index)
+		}
 	})
 
 	// Start worker pool
@@ -187,58 +439,174 @@ func (e *Engine) Download(ctx context.Context, manifest *models.Manifest) error
 		return nil
 	}
 
-	// Queue media segments (skip already completed ones for resume)
+	// Queue media segments in schedule order (skip already completed ones for resume)
 	totalSegments := 0
 	skippedSegments := 0
-	for _, track := range e.SelectedTracks {
-		for _, segment := range track.Segments {
-			totalSegments++
-
-			// Skip if already downloaded (resume)
-			if e.checkpoint.IsSegmentDone(track.ID, segment.Index) {
+	invalidSegments := 0
+	for _, task := range sched.Schedule(e.SelectedTracks) {
+		track, segment := task.Track, task.Segment
+		totalSegments++
+
+		// Skip if already downloaded and the file is intact (resume)
+		if e.checkpoint.IsSegmentDone(track.ID, segment.Index) {
+			if e.checkpoint.Verify(track.ID, segment.Index) {
 				segment.FilePath = e.checkpoint.SegmentPath(track.ID, segment.Index)
 				skippedSegments++
+				if stream != nil {
+					stream.Done(track.ID, segment.Index)
+				}
 				continue
 			}
+			invalidSegments++
+		}
 
-			task := &SegmentTask{
-				Segment: segment,
-				Track:   track,
-				Headers: e.cfg.Headers,
-			}
-			// Set appropriate decryption function
-			if track.Decryptor != nil {
-				task.DecFunc = cencDecFunc
-			} else if track.HLSDecryptor != nil {
-				task.DecFunc = hlsDecFunc
-			}
-			e.pool.Submit(task)
+		// Set appropriate decryption function
+		if track.Decryptor != nil {
+			task.DecFunc = cencDecFunc
+		} else if track.HLSDecryptor != nil {
+			task.DecFunc = hlsDecFunc
 		}
+		e.pool.Submit(task)
 	}
 
-	if e.cfg.Verbose && skippedSegments > 0 {
-		fmt.Printf("Resuming: skipped %d/%d segments\n", skippedSegments, totalSegments)
+	if skippedSegments > 0 {
+		e.log.DebugContext(ctx, "resuming", "skipped", skippedSegments, "total", totalSegments)
+	}
+	if invalidSegments > 0 {
+		e.log.DebugContext(ctx, "segment files missing or corrupt, downloading again", "segments", invalidSegments)
 	}
 
-	// Wait for completion
-	if err := e.pool.Wait(); err != nil {
-		// Save checkpoint for future resume
-		e.checkpoint.Save(e.checkpointPath)
+	// Wait for completion. The space watch goes on through muxing, which
+	// writes the output and may copy tracks.
+	gaps := e.pool.Wait()
+	stopAutoSave() // Final save, including partial segments
+	err = context.Cause(ctx)
+	if err == nil {
+		err = e.checkGaps(gaps)
+	}
+	if err != nil {
+		if stream != nil {
+			stream.Abort()
+		}
 		return err
 	}
 
-	// Success: clean up checkpoint and temp files after muxing
+	// Success: clean up checkpoint and temp files after muxing, unless a
+	// full disk stopped it; then a rerun resumes with just the mux
+	var diskFull bool
 	defer func() {
+		if diskFull {
+			return
+		}
 		os.Remove(e.checkpointPath)
-		os.RemoveAll(tempDir)
+		if err := e.checkpoint.CleanupTempDir(e.cfg.TempBase()); err != nil {
+			e.log.Warn("temp dir left in place", "path", tempDir, "error", err)
+		}
 	}()
 
-	if _, err := os.Stat(e.cfg.OutputDir); os.IsNotExist(err) {
-		os.MkdirAll(e.cfg.OutputDir, 0644)
+	// Mux tracks into final output
+	muxTracks := e.SelectedTracks
+	if stream != nil {
+		if err := stream.Close(); err != nil {
+			return fmt.Errorf("mux: %w", err)
+		}
+		// Only subtitles and thumbnails are left
+		muxTracks = slices.DeleteFunc(slices.Clone(muxTracks), isMediaTrack)
+	}
+	if e.cfg.Writer != nil {
+		// Nothing is written next to a stream
+		if len(muxTracks) > 0 {
+			e.log.WarnContext(ctx, "subtitle and thumbnail tracks skipped when writing to a stream", "tracks", len(muxTracks))
+		}
+		return nil
+	}
+	if len(muxTracks) > 0 {
+		if err := e.muxer.Mux(ctx, muxTracks, outputPath, format); err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrDiskSpace) {
+				diskFull = true
+				return cause
+			}
+			return err
+		}
 	}
+	stopWatch()
 
-	// Mux tracks into final output
-	return e.muxer.Mux(ctx, e.SelectedTracks, filepath.Join(e.cfg.OutputDir, e.cfg.FileName), ContainerFormat(e.cfg.Format))
+	gapsPath := gapsSidecarPath(outputPath, format)
+	if len(gaps) > 0 {
+		if err := writeGapReport(gapsPath, gaps, e.SelectedTracks); err != nil {
+			return fmt.Errorf("write gap report: %w", err)
+		}
+		e.log.InfoContext(ctx, "missing segments listed", "path", gapsPath)
+	} else {
+		os.Remove(gapsPath) // Stale report from an earlier run
+	}
+
+	if e.cfg.ExportEvents && len(manifest.Events) > 0 {
+		if err := writeEventsSidecar(eventsSidecarPath(outputPath, format), manifest.Events, e.SelectedTracks); err != nil {
+			return fmt.Errorf("write events: %w", err)
+		}
+	}
+	return nil
+}
+
+// setupCheckpoint loads or creates the checkpoint for outputPath and returns
+// the segment temp dir and a function that stops periodic saving. A stream
+// written to cfg.Writer can't be resumed, so it gets a private temp dir and
+// nothing is persisted.
+func (e *Engine) setupCheckpoint(outputPath string) (string, func() error, error) {
+	// The muxer's intermediates go here too, also when resuming elsewhere
+	if err := os.MkdirAll(e.cfg.TempBase(), 0755); err != nil {
+		return "", nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	if e.cfg.Writer != nil {
+		tempDir, err := os.MkdirTemp(e.cfg.TempBase(), tempDirPrefix+"stream_")
+		if err != nil {
+			return "", nil, fmt.Errorf("create temp dir: %w", err)
+		}
+		e.checkpointPath = ""
+		e.checkpoint = NewCheckpoint(e.cfg.URL, tempDir, e.SelectedTracks)
+		return tempDir, func() error { return nil }, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", nil, fmt.Errorf("create output dir: %w", err)
+	}
+	e.checkpointPath = CheckpointPath(outputPath)
+	tempDir := TempDirFor(e.cfg.TempBase(), outputPath, e.cfg.URL)
+
+	// Try to load existing checkpoint for resume
+	existingCP, _ := LoadCheckpoint(e.checkpointPath)
+	if existingCP != nil && existingCP.Matches(e.cfg.URL, e.SelectedTracks) &&
+		strings.HasPrefix(filepath.Base(existingCP.TempDir), tempDirPrefix) {
+		// Resume from existing checkpoint
+		tempDir = existingCP.TempDir
+		e.checkpoint = existingCP
+		e.log.Debug("resuming download from checkpoint", "path", e.checkpointPath)
+		// Pick up segments finished after the last save
+		if n := e.checkpoint.Rebuild(e.SelectedTracks); n > 0 {
+			e.log.Debug("recovered segments not in the checkpoint", "segments", n)
+		}
+	} else {
+		// Different URL, selection or manifest, or files left without a
+		// checkpoint: never mix old segments in
+		if existingCP != nil {
+			existingCP.CleanupTempDir(e.cfg.TempBase())
+			e.log.Debug("checkpoint does not match this download, starting over", "path", e.checkpointPath)
+		}
+		os.RemoveAll(tempDir)
+		e.checkpoint = NewCheckpoint(e.cfg.URL, tempDir, e.SelectedTracks)
+	}
+	// The temp dir may have been purged since the checkpoint was written
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("create temp dir: %w", err)
+	}
+
+	// Save now so segment files never exist without a checkpoint, then keep
+	// saving so a crash loses at most a few seconds of progress
+	if err := e.checkpoint.Save(e.checkpointPath); err != nil {
+		return "", nil, fmt.Errorf("save checkpoint: %w", err)
+	}
+	return tempDir, e.checkpoint.AutoSave(e.checkpointPath, checkpointSaveInterval), nil
 }
 
 // decryptTrack decrypts all segments in a track.
@@ -267,12 +635,32 @@ func (e *Engine) Progress() <-chan ProgressUpdate {
 	return e.progressCh
 }
 
-// Close releases engine resources.
+// Close releases engine resources and, with SaveCookies, writes cookies
+// changed by the server back to the cookie file.
 func (e *Engine) Close() error {
 	close(e.progressCh)
+	if e.bandwidth != nil {
+		e.bandwidth.Release()
+	}
+	if e.schedule != nil {
+		e.schedule.Stop()
+	}
+	if e.jar != nil && e.cfg.SaveCookies && e.jar.Changed() {
+		if err := e.jar.Save(e.cfg.CookieFile); err != nil {
+			return fmt.Errorf("save cookies: %w", err)
+		}
+	}
 	return nil
 }
 
+// SetBandwidthWeight changes the engine's weight in the shared bandwidth
+// budget. It has no effect without one.
+func (e *Engine) SetBandwidthWeight(weight float64) {
+	if e.bandwidth != nil {
+		e.bandwidth.SetWeight(weight)
+	}
+}
+
 // SetMuxer sets a custom muxer implementation.
 func (e *Engine) SetMuxer(m Muxer) {
 	e.muxer = m
@@ -284,43 +672,48 @@ func (e *Engine) downloadInitSegment(ctx context.Context, track *models.Track) e
 		return nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", track.InitSegment.URL, nil)
+	data, err := e.fetch(ctx, track.InitSegment.URL, track.InitSegment.ByteRange)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
+		return err
 	}
 
-	for k, v := range e.cfg.Headers {
-		req.Header.Set(k, v)
-	}
+	track.InitSegment.Data = data
 
-	if track.InitSegment.ByteRange != nil {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d",
-			track.InitSegment.ByteRange.Start,
-			track.InitSegment.ByteRange.End))
-	}
+	e.log.DebugContext(ctx, "downloaded init segment", "track", track.ID, "bytes", len(data))
 
-	resp, err := e.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
+// fetch downloads a playlist or init segment, retrying transient failures.
+func (e *Engine) fetch(ctx context.Context, url string, byteRange *models.ByteRange) ([]byte, error) {
+	var data []byte
+	err := e.retry.Logged(e.log, "url", httpclient.RedactURL(url)).Do(ctx, func(int) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return httpclient.Permanent(fmt.Errorf("create request: %w", err))
+		}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read body: %w", err)
-	}
+		if byteRange != nil {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", byteRange.Start, byteRange.End))
+		}
 
-	track.InitSegment.Data = data
+		resp, err := e.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+		defer resp.Body.Close()
 
-	if e.cfg.Verbose {
-		fmt.Printf("Downloaded init segment for %s: %d bytes\n", track.ID, len(data))
-	}
+		if err := httpclient.CheckResponse(resp); err != nil {
+			return err
+		}
 
-	return nil
+		data, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+		return nil
+	})
+	return data, err
 }
 
 // LoadTrackSegments fetches the media playlist and populates track segments.
@@ -330,39 +723,18 @@ func (e *Engine) LoadTrackSegments(ctx context.Context, track *models.Track) err
 		return nil
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", track.MediaPlaylistURL, nil)
+	content, err := e.fetch(ctx, track.MediaPlaylistURL, nil)
 	if err != nil {
-		return fmt.Errorf("create request: %w", err)
-	}
-
-	for k, v := range e.cfg.Headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := e.client.Do(req)
-	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
-	}
-
-	content, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("read body: %w", err)
+		return err
 	}
 
-	segments, initSeg := parser.ParseMediaPlaylist(string(content), track.MediaPlaylistURL)
+	segments, initSeg := parser.ParseMediaPlaylist(string(content), track.MediaPlaylistURL, track.PlaylistVars)
 	track.Segments = segments
 	if initSeg != nil {
 		track.InitSegment = initSeg
 	}
 
-	if e.cfg.Verbose {
-		fmt.Printf("Loaded %d segments for %s (init: %v)\n", len(segments), track.ID, initSeg != nil)
-	}
+	e.log.DebugContext(ctx, "loaded segments", "track", track.ID, "segments", len(segments), "init", initSeg != nil)
 
 	return nil
 }
@@ -0,0 +1,188 @@
+package engine
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// skipAdSegments drops segments whose midpoint falls inside an ad break.
+func skipAdSegments(segments []*models.Segment, breaks []*models.Event) []*models.Segment {
+	if len(breaks) == 0 {
+		return segments
+	}
+
+	kept := segments[:0:0]
+	for _, seg := range segments {
+		mid := seg.Start + seg.Duration/2
+		inBreak := false
+		for _, b := range breaks {
+			if b.Contains(mid) {
+				inBreak = true
+				break
+			}
+		}
+		if !inBreak {
+			kept = append(kept, seg)
+		}
+	}
+	return kept
+}
+
+// skipAds removes ad break segments from a track when enabled.
+func (e *Engine) skipAds(track *models.Track, manifest *models.Manifest) {
+	if !e.cfg.SkipAds || trackDuration(track.Segments) == 0 {
+		return
+	}
+
+	before, first := len(track.Segments), track.Segments[0]
+	track.Segments = skipAdSegments(track.Segments, manifest.AdBreaks())
+	if len(track.Segments) != before {
+		e.log.Debug("skipped ad segments", "track", track.ID, "segments", before-len(track.Segments))
+	}
+	if len(track.Segments) > 0 && track.Segments[0] != first {
+		// The time range began inside a skipped break
+		track.ClipStart = 0
+	}
+}
+
+// outputTime maps a stream presentation time to a time in the output file,
+// given the segments that were kept for a track. It returns false if t falls
+// in a part of the stream that is not in the output.
+func outputTime(segments []*models.Segment, clipStart, t time.Duration) (time.Duration, bool) {
+	var out time.Duration
+	for _, seg := range segments {
+		if t < seg.Start {
+			return 0, false
+		}
+		if t < seg.Start+seg.Duration {
+			out += t - seg.Start - clipStart
+			return max(out, 0), true
+		}
+		out += seg.Duration
+	}
+	return 0, false
+}
+
+// referenceTrack returns the track whose timeline is used for chapters.
+func referenceTrack(tracks []*models.Track) *models.Track {
+	for _, t := range tracks {
+		if t.IsVideo() && !t.IsSubtitle() {
+			return t
+		}
+	}
+	for _, t := range tracks {
+		if !t.IsSubtitle() && !t.IsThumbnail() {
+			return t
+		}
+	}
+	return nil
+}
+
+// Chapter is an event placed on the output timeline.
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+// buildChapters converts manifest events into chapters on the output timeline.
+// Events that fall outside the downloaded content are dropped.
+func buildChapters(events []*models.Event, tracks []*models.Track) []Chapter {
+	ref := referenceTrack(tracks)
+	if ref == nil || trackDuration(ref.Segments) == 0 {
+		return nil
+	}
+
+	var chapters []Chapter
+	for _, ev := range events {
+		start, ok := outputTime(ref.Segments, ref.ClipStart, ev.Start)
+		if !ok {
+			continue
+		}
+		end := start
+		if ev.Duration > 0 {
+			if e, ok := outputTime(ref.Segments, ref.ClipStart, ev.End()); ok {
+				end = e
+			} else {
+				end = start + ev.Duration
+			}
+		}
+		chapters = append(chapters, Chapter{
+			Title: eventTitle(ev),
+			Start: start,
+			End:   end,
+		})
+	}
+	return chapters
+}
+
+// eventTitle returns a human-readable label for an event.
+func eventTitle(ev *models.Event) string {
+	title := "Marker"
+	if ev.IsAdBreak() {
+		title = "Ad break"
+	}
+	if ev.ID != "" {
+		title += " " + ev.ID
+	}
+	return title
+}
+
+// ChapterMuxer is implemented by muxers that can embed chapters.
+type ChapterMuxer interface {
+	SetChapters(chapters []Chapter)
+}
+
+// eventRecord is the JSON sidecar representation of an event.
+type eventRecord struct {
+	ID       string            `json:"id,omitempty"`
+	Type     string            `json:"type"`
+	Class    string            `json:"class,omitempty"`
+	Start    float64           `json:"start"`
+	Duration float64           `json:"duration,omitempty"`
+	SCTE35   string            `json:"scte35,omitempty"`
+	Attrs    map[string]string `json:"attributes,omitempty"`
+
+	// Position in the output file, absent if the event was not downloaded
+	OutputStart *float64 `json:"output_start,omitempty"`
+}
+
+// writeEventsSidecar writes all manifest events as JSON next to the output.
+func writeEventsSidecar(path string, events []*models.Event, tracks []*models.Track) error {
+	ref := referenceTrack(tracks)
+
+	records := make([]eventRecord, 0, len(events))
+	for _, ev := range events {
+		rec := eventRecord{
+			ID:       ev.ID,
+			Type:     ev.Type.String(),
+			Class:    ev.Class,
+			Start:    ev.Start.Seconds(),
+			Duration: ev.Duration.Seconds(),
+			SCTE35:   ev.SCTE35,
+			Attrs:    ev.Attributes,
+		}
+		if ref != nil {
+			if t, ok := outputTime(ref.Segments, ref.ClipStart, ev.Start); ok {
+				s := t.Seconds()
+				rec.OutputStart = &s
+			}
+		}
+		records = append(records, rec)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// eventsSidecarPath returns the sidecar path for an output file.
+func eventsSidecarPath(outputPath string, format ContainerFormat) string {
+	return strings.TrimSuffix(outputPath, "."+string(format)) + ".events.json"
+}
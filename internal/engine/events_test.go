@@ -0,0 +1,241 @@
+package engine
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/config"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func adBreak(start, dur time.Duration) *models.Event {
+	return &models.Event{Type: models.EventAdBreak, Start: start, Duration: dur}
+}
+
+func segmentIndices(segs []*models.Segment) []int {
+	var indices []int
+	for _, seg := range segs {
+		indices = append(indices, seg.Index)
+	}
+	return indices
+}
+
+func TestSkipAdSegments(t *testing.T) {
+	tests := []struct {
+		name   string
+		breaks []*models.Event
+		want   []int
+	}{
+		{"no breaks", nil, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{"aligned", []*models.Event{adBreak(12*time.Second, 12*time.Second)}, []int{0, 1, 4, 5, 6, 7, 8, 9}},
+		// Segments go when their midpoint is in the break: 15s and 21s, not 27s
+		{"unaligned", []*models.Event{adBreak(14*time.Second, 13*time.Second)}, []int{0, 1, 4, 5, 6, 7, 8, 9}},
+		{"two breaks", []*models.Event{adBreak(0, 6*time.Second), adBreak(54*time.Second, 6*time.Second)}, []int{1, 2, 3, 4, 5, 6, 7, 8}},
+		{"shorter than a segment", []*models.Event{adBreak(13*time.Second, time.Second)}, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+
+	for _, tt := range tests {
+		segs := makeSegments(10, 6*time.Second) // 0-60s
+		got := segmentIndices(skipAdSegments(segs, tt.breaks))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSkipAdsClipStart(t *testing.T) {
+	tests := []struct {
+		name          string
+		clipStart     time.Duration
+		brk           *models.Event
+		wantFirst     int
+		wantClipStart time.Duration
+	}{
+		{"break after the clip", 13 * time.Second, adBreak(24*time.Second, 12*time.Second), 2, time.Second},
+		// The output can't start 1s into segment 4 when it was meant for segment 2
+		{"clip inside the break", 13 * time.Second, adBreak(12*time.Second, 12*time.Second), 4, 0},
+	}
+
+	for _, tt := range tests {
+		cfg := config.New()
+		cfg.SkipAds = true
+		cfg.StartTime = tt.clipStart
+		e := &Engine{cfg: cfg, log: slog.New(slog.DiscardHandler)}
+		track := &models.Track{ID: "v", Segments: makeSegments(10, 6*time.Second)}
+		if err := e.clipTrack(track); err != nil {
+			t.Fatal(err)
+		}
+		e.skipAds(track, &models.Manifest{Events: []*models.Event{tt.brk}})
+		if track.Segments[0].Index != tt.wantFirst {
+			t.Errorf("%s: first segment %d, want %d", tt.name, track.Segments[0].Index, tt.wantFirst)
+		}
+		if track.ClipStart != tt.wantClipStart {
+			t.Errorf("%s: ClipStart %s, want %s", tt.name, track.ClipStart, tt.wantClipStart)
+		}
+	}
+}
+
+// clipAndSkip returns the kept segments of a 0-60s track of 6s segments
+// and the offset into the first one, as clipTrack and skipAds leave them.
+func clipAndSkip(start time.Duration, breaks ...*models.Event) ([]*models.Segment, time.Duration) {
+	segs := makeSegments(10, 6*time.Second)
+	var clipStart time.Duration
+	if start > 0 {
+		segs = clipSegments(segs, start, 0)
+		clipStart = start - segs[0].Start
+	}
+	return skipAdSegments(segs, breaks), clipStart
+}
+
+func TestOutputTime(t *testing.T) {
+	skip := adBreak(24*time.Second, 12*time.Second) // Segments 4 and 5
+
+	tests := []struct {
+		name      string
+		clipStart time.Duration
+		breaks    []*models.Event
+		t         time.Duration
+		want      time.Duration
+		wantOK    bool
+	}{
+		{"whole stream", 0, nil, 20 * time.Second, 20 * time.Second, true},
+		{"past the end", 0, nil, 60 * time.Second, 0, false},
+		// Clipped at 13s: segment 2 (12s) is first, 1s into it
+		{"clip start", 13 * time.Second, nil, 13 * time.Second, 0, true},
+		{"after clip", 13 * time.Second, nil, 30 * time.Second, 17 * time.Second, true},
+		{"before clip in first segment", 13 * time.Second, nil, 12500 * time.Millisecond, 0, true},
+		{"before first segment", 13 * time.Second, nil, 5 * time.Second, 0, false},
+		{"before break", 0, []*models.Event{skip}, 20 * time.Second, 20 * time.Second, true},
+		{"inside skipped break", 0, []*models.Event{skip}, 30 * time.Second, 0, false},
+		{"after break", 0, []*models.Event{skip}, 40 * time.Second, 28 * time.Second, true},
+		{"clip and break", 13 * time.Second, []*models.Event{skip}, 40 * time.Second, 15 * time.Second, true},
+	}
+
+	for _, tt := range tests {
+		segs, clipStart := clipAndSkip(tt.clipStart, tt.breaks...)
+		got, ok := outputTime(segs, clipStart, tt.t)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("%s: outputTime(%s) = %s, %v; want %s, %v", tt.name, tt.t, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestBuildChapters(t *testing.T) {
+	skip := adBreak(24*time.Second, 12*time.Second)
+	skip.ID = "1"
+
+	tests := []struct {
+		name      string
+		clipStart time.Duration
+		breaks    []*models.Event
+		events    []*models.Event
+		want      []Chapter
+	}{
+		{
+			name:   "whole stream",
+			events: []*models.Event{{ID: "intro", Start: 0, Duration: 10 * time.Second}, skip},
+			want: []Chapter{
+				{"Marker intro", 0, 10 * time.Second},
+				{"Ad break 1", 24 * time.Second, 36 * time.Second},
+			},
+		},
+		{
+			name:   "skipped break",
+			breaks: []*models.Event{skip},
+			events: []*models.Event{
+				skip,
+				{ID: "a", Start: 40 * time.Second},
+				// Ends inside the skipped break: keeps its length
+				{ID: "b", Start: 20 * time.Second, Duration: 10 * time.Second},
+			},
+			want: []Chapter{
+				{"Marker a", 28 * time.Second, 28 * time.Second},
+				{"Marker b", 20 * time.Second, 30 * time.Second},
+			},
+		},
+		{
+			name:      "clip and skipped break",
+			clipStart: 13 * time.Second,
+			breaks:    []*models.Event{skip},
+			events: []*models.Event{
+				{ID: "early", Start: 5 * time.Second},
+				{ID: "a", Start: 40 * time.Second, Duration: 5 * time.Second},
+				skip,
+			},
+			want: []Chapter{{"Marker a", 15 * time.Second, 20 * time.Second}},
+		},
+	}
+
+	for _, tt := range tests {
+		segs, clipStart := clipAndSkip(tt.clipStart, tt.breaks...)
+		tracks := []*models.Track{
+			{ID: "a", Type: models.TrackAudio, Segments: makeSegments(1, 60*time.Second)},
+			{ID: "v", Type: models.TrackVideo, Segments: segs, ClipStart: clipStart},
+		}
+		got := buildChapters(tt.events, tracks)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWriteEventsSidecar(t *testing.T) {
+	skip := adBreak(24*time.Second, 12*time.Second)
+	skip.SCTE35 = "0xFC30"
+	segs, clipStart := clipAndSkip(13*time.Second, skip)
+	tracks := []*models.Track{{ID: "v", Type: models.TrackVideo, Segments: segs, ClipStart: clipStart}}
+	events := []*models.Event{
+		{ID: "early", Start: 5 * time.Second},
+		skip,
+		{ID: "a", Class: "com.example", Start: 40 * time.Second, Attributes: map[string]string{"X-TITLE": "A"}},
+	}
+
+	path := filepath.Join(t.TempDir(), "out.events.json")
+	if err := writeEventsSidecar(path, events, tracks); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []eventRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every event is listed; only downloaded ones have an output position
+	if len(records) != len(events) {
+		t.Fatalf("%d records, want %d", len(records), len(events))
+	}
+	for i, want := range []struct {
+		typ         string
+		start       float64
+		outputStart float64 // -1 = absent
+	}{
+		{"marker", 5, -1},
+		{"ad", 24, -1},
+		{"marker", 40, 15},
+	} {
+		rec := records[i]
+		if rec.Type != want.typ || rec.Start != want.start {
+			t.Errorf("record %d: type %q start %g, want %q %g", i, rec.Type, rec.Start, want.typ, want.start)
+		}
+		switch {
+		case want.outputStart < 0 && rec.OutputStart != nil:
+			t.Errorf("record %d: output_start %g, want none", i, *rec.OutputStart)
+		case want.outputStart >= 0 && (rec.OutputStart == nil || *rec.OutputStart != want.outputStart):
+			t.Errorf("record %d: output_start %v, want %g", i, rec.OutputStart, want.outputStart)
+		}
+	}
+	if records[1].SCTE35 != "0xFC30" || records[1].Duration != 12 {
+		t.Errorf("ad break record = %+v", records[1])
+	}
+	if records[2].Class != "com.example" || records[2].Attrs["X-TITLE"] != "A" {
+		t.Errorf("marker record = %+v", records[2])
+	}
+}
@@ -0,0 +1,161 @@
+package engine
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// Gap is a segment that could not be downloaded.
+type Gap struct {
+	TrackID  string
+	Index    int
+	Start    time.Duration // Position in the stream
+	Duration time.Duration
+	Err      error
+}
+
+// sortGaps orders gaps by track and segment index.
+func sortGaps(gaps []Gap) {
+	slices.SortFunc(gaps, func(a, b Gap) int {
+		return cmp.Or(strings.Compare(a.TrackID, b.TrackID), cmp.Compare(a.Index, b.Index))
+	})
+}
+
+// MissingSegmentsError is returned when more segments failed than the
+// failure tolerance allows. Gaps are sorted by track and segment index.
+type MissingSegmentsError struct {
+	Gaps  []Gap
+	Total int // Segments in the download
+}
+
+func (e *MissingSegmentsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d segments failed:", len(e.Gaps), e.Total)
+	for i := 0; i < len(e.Gaps); {
+		j := i
+		for j < len(e.Gaps) && e.Gaps[j].TrackID == e.Gaps[i].TrackID {
+			j++
+		}
+		indices := make([]int, 0, j-i)
+		for _, g := range e.Gaps[i:j] {
+			indices = append(indices, g.Index)
+		}
+		if i > 0 {
+			b.WriteString(";")
+		}
+		fmt.Fprintf(&b, " %s [%s]", e.Gaps[i].TrackID, formatIndexRanges(indices))
+		i = j
+	}
+	if len(e.Gaps) > 0 && e.Gaps[0].Err != nil {
+		fmt.Fprintf(&b, " (first error: %v)", e.Gaps[0].Err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of each failed segment.
+func (e *MissingSegmentsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Gaps))
+	for _, g := range e.Gaps {
+		if g.Err != nil {
+			errs = append(errs, g.Err)
+		}
+	}
+	return errs
+}
+
+// formatIndexRanges formats sorted indices compactly, e.g. "2, 5-7, 9".
+func formatIndexRanges(indices []int) string {
+	var parts []string
+	for i := 0; i < len(indices); {
+		j := i
+		for j+1 < len(indices) && indices[j+1] == indices[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(indices[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", indices[i], indices[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// checkGaps fails the download if the failed segments exceed the configured
+// tolerance, and otherwise warns about them.
+func (e *Engine) checkGaps(gaps []Gap) error {
+	if len(gaps) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, t := range e.SelectedTracks {
+		total += len(t.Segments)
+	}
+	if !e.cfg.FailureTolerance.Allows(len(gaps), total) {
+		return &MissingSegmentsError{Gaps: gaps, Total: total}
+	}
+
+	e.log.Warn("segments failed and are missing from the output",
+		"failed", len(gaps), "total", total, "tolerance", e.cfg.FailureTolerance.String())
+	return nil
+}
+
+// gapRecord is the JSON sidecar representation of a missing segment.
+type gapRecord struct {
+	Track    string  `json:"track"`
+	Index    int     `json:"index"`
+	Start    float64 `json:"start"`
+	Duration float64 `json:"duration,omitempty"`
+	Error    string  `json:"error,omitempty"`
+
+	// Position in the output file where the gap begins
+	OutputStart float64 `json:"output_start"`
+}
+
+// writeGapReport writes the segments missing from the output as JSON next
+// to it, so a gap can be found and patched later.
+func writeGapReport(path string, gaps []Gap, tracks []*models.Track) error {
+	byID := make(map[string]*models.Track, len(tracks))
+	for _, t := range tracks {
+		byID[t.ID] = t
+	}
+
+	records := make([]gapRecord, 0, len(gaps))
+	for _, g := range gaps {
+		rec := gapRecord{
+			Track:    g.TrackID,
+			Index:    g.Index,
+			Start:    g.Start.Seconds(),
+			Duration: g.Duration.Seconds(),
+		}
+		if g.Err != nil {
+			rec.Error = g.Err.Error()
+		}
+		if t := byID[g.TrackID]; t != nil {
+			if out, ok := outputTime(t.Segments, t.ClipStart, g.Start); ok {
+				rec.OutputStart = out.Seconds()
+			}
+		}
+		records = append(records, rec)
+	}
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// gapsSidecarPath returns the gap report path for an output file.
+func gapsSidecarPath(outputPath string, format ContainerFormat) string {
+	return strings.TrimSuffix(outputPath, "."+string(format)) + ".gaps.json"
+}
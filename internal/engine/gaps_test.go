@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mohaanymo/veld/internal/config"
+	"github.com/mohaanymo/veld/internal/httpclient"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestCheckGaps(t *testing.T) {
+	notFound := &httpclient.StatusError{StatusCode: http.StatusNotFound}
+	gaps := []Gap{
+		{TrackID: "a1", Index: 2, Err: notFound},
+		{TrackID: "v1", Index: 4, Err: notFound},
+		{TrackID: "v1", Index: 5, Err: notFound},
+		{TrackID: "v1", Index: 6, Err: notFound},
+		{TrackID: "v1", Index: 9, Err: notFound},
+	}
+	segments := make([]*models.Segment, 50)
+	for i := range segments {
+		segments[i] = &models.Segment{Index: i}
+	}
+	var logs bytes.Buffer
+	e := &Engine{
+		cfg: &config.Config{},
+		log: slog.New(slog.NewTextHandler(&logs, nil)),
+		SelectedTracks: []*models.Track{
+			{ID: "v1", Segments: segments},
+			{ID: "a1", Segments: segments},
+		},
+	}
+
+	err := e.checkGaps(gaps)
+	var missing *MissingSegmentsError
+	if !errors.As(err, &missing) {
+		t.Fatalf("strict tolerance: err = %v, want *MissingSegmentsError", err)
+	}
+	if missing.Total != 100 || len(missing.Gaps) != 5 {
+		t.Errorf("Total = %d, gaps = %d; want 100, 5", missing.Total, len(missing.Gaps))
+	}
+	want := "5 of 100 segments failed: a1 [2]; v1 [4-6, 9] (first error: HTTP 404)"
+	if err.Error() != want {
+		t.Errorf("error = %q, want %q", err.Error(), want)
+	}
+	var se *httpclient.StatusError
+	if !errors.As(err, &se) {
+		t.Error("segment errors not unwrapped")
+	}
+
+	e.cfg.FailureTolerance = config.Tolerance{MaxPercent: 5}
+	if err := e.checkGaps(gaps); err != nil {
+		t.Errorf("5%% tolerance: err = %v, want nil", err)
+	}
+	if !strings.Contains(logs.String(), "level=WARN msg=\"segments failed and are missing from the output\" failed=5 total=100") {
+		t.Errorf("tolerated gaps logged as %q", logs.String())
+	}
+}
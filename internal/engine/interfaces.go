@@ -13,6 +13,7 @@ type ProgressUpdate struct {
 	BytesLoaded  int64
 	Completed    bool
 	Error        error
+	Concurrency  int // Workers allowed to download at the time of the update
 }
 
 // Decryptor interface for pluggable decryption.
@@ -1,13 +1,17 @@
 package engine
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mohaanymo/veld/internal/config"
 	"github.com/mohaanymo/veld/internal/models"
@@ -18,15 +22,30 @@ type AutoMuxer struct {
 	ffmpegPath string
 	tempDir    string
 	backend    string
-	verbose    bool
+	log        *slog.Logger
+	messages   io.Writer // Notes for the user, may be nil
+	writer     io.Writer // Stream output here instead of a file (MuxStream only)
+
+	// Time-range clipping: trim each input by its track's ClipStart and
+	// limit the output to clipLength (0 = no limit)
+	clip       bool
+	clipLength time.Duration
+
+	chapters []Chapter
 }
 
 // NewAutoMuxer creates a new auto-selecting muxer.
 func NewAutoMuxer(cfg *config.Config) *AutoMuxer {
 	m := &AutoMuxer{
-		tempDir: os.TempDir(),
-		backend: cfg.MuxerBackend,
-		verbose: cfg.Verbose,
+		tempDir:  cfg.TempBase(),
+		backend:  cfg.MuxerBackend,
+		log:      cfg.Log(),
+		messages: cfg.Messages,
+		writer:   cfg.Writer,
+		clip:     cfg.HasTimeRange(),
+	}
+	if cfg.EndTime > 0 {
+		m.clipLength = cfg.EndTime - cfg.StartTime
 	}
 
 	if path, err := exec.LookPath("ffmpeg"); err == nil {
@@ -36,41 +55,35 @@ func NewAutoMuxer(cfg *config.Config) *AutoMuxer {
 	return m
 }
 
+// SetChapters sets chapters to embed in the output (FFmpeg only).
+func (m *AutoMuxer) SetChapters(chapters []Chapter) {
+	m.chapters = chapters
+}
+
 // Mux combines tracks into the output file.
 func (m *AutoMuxer) Mux(ctx context.Context, tracks []*models.Track, outputPath string, format ContainerFormat) error {
 	if len(tracks) == 0 {
 		return fmt.Errorf("no tracks to mux")
 	}
 
-	// Determine output path with proper extension
-	if outputPath == "" {
-		outputPath = "output"
-	}
-
-	ext := "." + string(format)
-	if !strings.HasSuffix(strings.ToLower(outputPath), ext) {
-		outputPath = outputPath + ext
-	}
-
-	if !filepath.IsAbs(outputPath) {
-		cwd, _ := os.Getwd()
-		outputPath = filepath.Join(cwd, outputPath)
+	outputPath, err := resolveOutputPath(outputPath, format)
+	if err != nil {
+		return err
 	}
-
 	outputDir := filepath.Dir(outputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("create output dir: %w", err)
-	}
-
-	baseName := strings.TrimSuffix(filepath.Base(outputPath), ext)
+	baseName := strings.TrimSuffix(filepath.Base(outputPath), "."+string(format))
 
-	// Separate media tracks from subtitles
+	// Separate media tracks from subtitles and thumbnails
 	var mediaTracks []*models.Track
 	var subtitleTracks []*models.Track
+	var thumbnailTracks []*models.Track
 	for _, t := range tracks {
-		if t.IsSubtitle() {
+		switch {
+		case t.IsThumbnail():
+			thumbnailTracks = append(thumbnailTracks, t)
+		case t.IsSubtitle():
 			subtitleTracks = append(subtitleTracks, t)
-		} else {
+		default:
 			mediaTracks = append(mediaTracks, t)
 		}
 	}
@@ -79,21 +92,31 @@ func (m *AutoMuxer) Mux(ctx context.Context, tracks []*models.Track, outputPath
 	for _, sub := range subtitleTracks {
 		subPath := m.subtitlePath(outputDir, baseName, sub)
 		if err := m.saveSubtitle(sub, subPath); err != nil {
-			if m.verbose {
-				fmt.Printf("Warning: failed to save subtitle %s: %v\n", sub.ID, err)
-			}
+			m.log.WarnContext(ctx, "failed to save subtitle", "track", sub.ID, "error", err)
 		} else {
-			fmt.Printf("✓ Subtitle saved: %s\n", subPath)
+			m.log.DebugContext(ctx, "saved subtitle", "track", sub.ID, "path", subPath)
+			if m.messages != nil {
+				fmt.Fprintf(m.messages, "✓ Subtitle saved: %s\n", subPath)
+			}
 		}
 	}
 
+	// Save thumbnail tracks as an image sequence next to the output
+	if len(thumbnailTracks) > 0 {
+		thumbDir := filepath.Join(outputDir, baseName+".thumbs")
+		for _, thumb := range thumbnailTracks {
+			if err := m.saveThumbnails(ctx, thumb, thumbDir); err != nil {
+				return fmt.Errorf("save thumbnails %s: %w", thumb.ID, err)
+			}
+		}
+		m.log.DebugContext(ctx, "saved thumbnails", "path", thumbDir)
+	}
+
 	if len(mediaTracks) == 0 {
 		return nil
 	}
 
-	if m.verbose {
-		fmt.Printf("Muxing %d media tracks to: %s\n", len(mediaTracks), outputPath)
-	}
+	m.log.DebugContext(ctx, "muxing", "tracks", len(mediaTracks), "path", outputPath)
 
 	// Concatenate segments for each track to temp files
 	tempFiles := make([]string, 0, len(mediaTracks))
@@ -111,17 +134,21 @@ func (m *AutoMuxer) Mux(ctx context.Context, tracks []*models.Track, outputPath
 			return fmt.Errorf("concat track %s: %w", track.ID, err)
 		}
 
-		if m.verbose {
-			info, _ := os.Stat(tempPath)
-			if info != nil {
-				fmt.Printf("Track %s (%s): %d bytes\n", track.ID, track.Type, info.Size())
-			}
+		if info, err := os.Stat(tempPath); err == nil {
+			m.log.DebugContext(ctx, "concatenated track", "track", track.ID, "type", track.Type.String(), "bytes", info.Size())
 		}
 	}
 
 	// Use FFmpeg if available
-	if m.ffmpegPath != "" && (m.backend == "auto" || m.backend == "ffmpeg") {
-		return m.muxWithFFmpeg(ctx, tempFiles, mediaTracks, outputPath, format)
+	if m.useFFmpeg() {
+		metaPath, err := m.writeChapters(baseName, format)
+		if err != nil {
+			return err
+		}
+		if metaPath != "" {
+			defer os.Remove(metaPath)
+		}
+		return m.muxWithFFmpeg(ctx, tempFiles, metaPath, mediaTracks, outputPath, format)
 	}
 
 	// Binary concat for single track or TS format
@@ -132,6 +159,47 @@ func (m *AutoMuxer) Mux(ctx context.Context, tracks []*models.Track, outputPath
 	return fmt.Errorf("FFmpeg required for multi-track muxing to %s", format)
 }
 
+// useFFmpeg reports whether FFmpeg is available and allowed by the backend.
+func (m *AutoMuxer) useFFmpeg() bool {
+	return m.ffmpegPath != "" && (m.backend == "auto" || m.backend == "ffmpeg")
+}
+
+// writeChapters writes the chapters as an FFmpeg metadata file and returns
+// its path, or "" if there is nothing to embed.
+func (m *AutoMuxer) writeChapters(baseName string, format ContainerFormat) (string, error) {
+	if len(m.chapters) == 0 || format == FormatTS {
+		return "", nil
+	}
+	metaPath := filepath.Join(m.tempDir, fmt.Sprintf("veld_chapters_%s.txt", sanitizeID(baseName)))
+	if err := writeFFMetadata(metaPath, m.chapters); err != nil {
+		return "", fmt.Errorf("write chapters: %w", err)
+	}
+	return metaPath, nil
+}
+
+// resolveOutputPath returns the absolute output path with the format's
+// extension, creating its directory.
+func resolveOutputPath(outputPath string, format ContainerFormat) (string, error) {
+	if outputPath == "" {
+		outputPath = "output"
+	}
+
+	ext := "." + string(format)
+	if !strings.HasSuffix(strings.ToLower(outputPath), ext) {
+		outputPath = outputPath + ext
+	}
+
+	if !filepath.IsAbs(outputPath) {
+		cwd, _ := os.Getwd()
+		outputPath = filepath.Join(cwd, outputPath)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0755); err != nil {
+		return "", fmt.Errorf("create output dir: %w", err)
+	}
+	return outputPath, nil
+}
+
 // subtitlePath generates a path for a subtitle file.
 func (m *AutoMuxer) subtitlePath(dir, baseName string, sub *models.Track) string {
 	ext := getSubtitleExt(sub.Codec)
@@ -173,6 +241,68 @@ func (m *AutoMuxer) saveSubtitle(track *models.Track, path string) error {
 	return nil
 }
 
+// saveThumbnails writes a thumbnail track to dir as numbered images.
+// Image tile segments are written as-is; I-frame tracks are decoded to JPEG
+// frames with FFmpeg, or kept as a single stream file without it.
+func (m *AutoMuxer) saveThumbnails(ctx context.Context, track *models.Track, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	prefix := filepath.Join(dir, sanitizeID(track.ID))
+
+	if track.IsImage() {
+		ext := ".jpg"
+		switch codec := strings.ToLower(track.Codec); {
+		case strings.Contains(codec, "png"):
+			ext = ".png"
+		case strings.Contains(codec, "webp"):
+			ext = ".webp"
+		}
+		for i, seg := range track.Segments {
+			data, err := segmentData(seg)
+			if err != nil {
+				return fmt.Errorf("read segment %d: %w", i, err)
+			}
+			if len(data) == 0 {
+				continue
+			}
+			if err := os.WriteFile(fmt.Sprintf("%s_%05d%s", prefix, i+1, ext), data, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// I-frame playlist: concatenate the frames into one stream first
+	streamPath := filepath.Join(m.tempDir, fmt.Sprintf("veld_iframes_%s.tmp", sanitizeID(track.ID)))
+	if err := m.concatSegments(track, streamPath); err != nil {
+		return err
+	}
+	defer os.Remove(streamPath)
+
+	if m.ffmpegPath == "" {
+		return m.binaryCopy(streamPath, prefix+"_iframes.ts")
+	}
+
+	args := []string{"-y", "-hide_banner", "-loglevel", "error",
+		"-i", streamPath, "-vsync", "0", "-q:v", "3", prefix + "_%05d.jpg"}
+	cmd := exec.CommandContext(ctx, m.ffmpegPath, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// segmentData returns a segment's data from disk or memory.
+func segmentData(seg *models.Segment) ([]byte, error) {
+	if seg.FilePath != "" {
+		return os.ReadFile(seg.FilePath)
+	}
+	return seg.Data, nil
+}
+
 // sanitizeID makes track ID safe for filenames.
 func sanitizeID(id string) string {
 	replacer := strings.NewReplacer("/", "_", "\\", "_", ":", "_", " ", "_")
@@ -196,29 +326,19 @@ func (m *AutoMuxer) concatSegments(track *models.Track, outputPath string) error
 			return fmt.Errorf("write init segment: %w", err)
 		}
 		bytesWritten += int64(n)
-		if m.verbose {
-			fmt.Printf("  Init segment for %s: %d bytes\n", track.ID, n)
-		}
+		m.log.Debug("wrote init segment", "track", track.ID, "bytes", n)
 	}
 
 	// Write media segments in order
 	for i, seg := range track.Segments {
-		var data []byte
-
 		// Read from disk if FilePath is set, otherwise use in-memory data
-		if seg.FilePath != "" {
-			data, err = os.ReadFile(seg.FilePath)
-			if err != nil {
-				return fmt.Errorf("read segment %d from disk: %w", i, err)
-			}
-		} else {
-			data = seg.Data
+		data, err := segmentData(seg)
+		if err != nil {
+			return fmt.Errorf("read segment %d from disk: %w", i, err)
 		}
 
 		if len(data) == 0 {
-			if m.verbose {
-				fmt.Printf("  Warning: segment %d has no data\n", i)
-			}
+			m.log.Warn("segment has no data", "track", track.ID, "segment", seg.Index)
 			continue
 		}
 		n, err := f.Write(data)
@@ -237,19 +357,41 @@ func (m *AutoMuxer) concatSegments(track *models.Track, outputPath string) error
 
 // muxWithFFmpeg uses FFmpeg to mux tracks.
 // FIXED: Use -map 0 -map 1 etc. to map ALL streams from each input, not just stream 0.
-func (m *AutoMuxer) muxWithFFmpeg(ctx context.Context, inputFiles []string, tracks []*models.Track, output string, format ContainerFormat) error {
+func (m *AutoMuxer) muxWithFFmpeg(ctx context.Context, inputFiles []string, metaPath string, tracks []*models.Track, output string, format ContainerFormat) error {
+	cmd := m.ffmpegCommand(ctx, inputFiles, metaPath, tracks, output, format)
+	stderr := m.ffmpegOutput(ctx)
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return nil
+}
+
+// ffmpegCommand builds the FFmpeg command that muxes inputs (one per track)
+// into output.
+func (m *AutoMuxer) ffmpegCommand(ctx context.Context, inputFiles []string, metaPath string, tracks []*models.Track, output string, format ContainerFormat) *exec.Cmd {
 	args := []string{"-y", "-hide_banner"}
 
-	if !m.verbose {
-		args = append(args, "-loglevel", "error")
+	if m.log.Enabled(ctx, slog.LevelDebug) {
+		args = append(args, "-loglevel", "info", "-nostats")
 	} else {
-		args = append(args, "-loglevel", "info")
+		args = append(args, "-loglevel", "error")
 	}
 
-	// Add inputs
-	for _, f := range inputFiles {
+	// Add inputs, seeking each one to the start of the requested range
+	for i, f := range inputFiles {
+		if m.clip && tracks[i].ClipStart > 0 {
+			args = append(args, "-ss", formatSeconds(tracks[i].ClipStart))
+		}
 		args = append(args, "-i", f)
 	}
+	if metaPath != "" {
+		args = append(args, "-i", metaPath)
+	}
+	if m.clip && m.clipLength > 0 {
+		args = append(args, "-t", formatSeconds(m.clipLength))
+	}
 
 	// Copy codecs (no re-encoding)
 	args = append(args, "-c", "copy")
@@ -260,33 +402,130 @@ func (m *AutoMuxer) muxWithFFmpeg(ctx context.Context, inputFiles []string, trac
 	for i := range inputFiles {
 		args = append(args, "-map", fmt.Sprintf("%d", i))
 	}
+	if metaPath != "" {
+		args = append(args, "-map_chapters", fmt.Sprintf("%d", len(inputFiles)))
+	}
 
-	// For MP4/MOV, use faststart for web playback
-	if format == FormatMP4 {
+	switch {
+	case output == ffmpegStdout:
+		// A pipe can't be seeked back into, so MP4 must be fragmented
+		args = append(args, "-f", ffmpegFormat(format))
+		if format == FormatMP4 {
+			args = append(args, "-movflags", "frag_keyframe+empty_moov+default_base_moof")
+		}
+	case format == FormatMP4:
+		// For MP4/MOV, use faststart for web playback
 		args = append(args, "-movflags", "+faststart")
 	}
 
 	args = append(args, output)
 
-	if m.verbose {
-		fmt.Printf("FFmpeg command: %s %s\n", m.ffmpegPath, strings.Join(args, " "))
+	m.log.DebugContext(ctx, "running ffmpeg", "command", m.ffmpegPath+" "+strings.Join(args, " "))
+
+	return exec.CommandContext(ctx, m.ffmpegPath, args...)
+}
+
+// ffmpegOutput returns a writer for FFmpeg's stderr, see ffmpegLog.
+func (m *AutoMuxer) ffmpegOutput(ctx context.Context) *ffmpegLog {
+	return &ffmpegLog{ctx: ctx, log: m.log}
+}
+
+// ffmpegTailSize is how much of FFmpeg's output is kept for an error
+// message.
+const ffmpegTailSize = 4 << 10
+
+// ffmpegLog keeps the end of what FFmpeg writes to stderr for error
+// messages, and logs each line at debug level as it arrives, so FFmpeg's
+// progress shows while it runs.
+type ffmpegLog struct {
+	ctx       context.Context
+	log       *slog.Logger
+	tail      []byte // Last ffmpegTailSize bytes of output
+	truncated bool   // Output before tail was dropped
+	partial   []byte // Last line, until it ends
+}
+
+func (f *ffmpegLog) Write(p []byte) (int, error) {
+	f.tail = append(f.tail, p...)
+	if extra := len(f.tail) - ffmpegTailSize; extra > 0 {
+		// Start at a line if one begins soon after the cut
+		cut := f.tail[extra:]
+		if i := bytes.IndexAny(cut, "\r\n"); i >= 0 && i < 256 {
+			cut = cut[i+1:]
+		}
+		f.tail = append(f.tail[:0], cut...)
+		f.truncated = true
 	}
 
-	cmd := exec.CommandContext(ctx, m.ffmpegPath, args...)
-	var stderr strings.Builder
-	cmd.Stderr = &stderr
-	if m.verbose {
-		cmd.Stderr = os.Stderr
+	f.partial = append(f.partial, p...)
+	for {
+		i := bytes.IndexAny(f.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		f.logLine(f.partial[:i])
+		f.partial = f.partial[i+1:]
+	}
+	if len(f.partial) > ffmpegTailSize {
+		// Not a line FFmpeg would write; log it rather than keep it
+		f.logLine(f.partial)
+		f.partial = f.partial[:0]
 	}
+	return len(p), nil
+}
 
-	if err := cmd.Run(); err != nil {
-		if !m.verbose {
-			return fmt.Errorf("%w: %s", err, stderr.String())
+func (f *ffmpegLog) logLine(line []byte) {
+	if line := strings.TrimSpace(string(line)); line != "" {
+		f.log.DebugContext(f.ctx, "ffmpeg output", "output", line)
+	}
+}
+
+// String returns the end of the output, for an error message.
+func (f *ffmpegLog) String() string {
+	out := strings.TrimSpace(string(f.tail))
+	if f.truncated {
+		out = "...\n" + out
+	}
+	return out
+}
+
+// ffmpegStdout is the FFmpeg output name for writing to stdout.
+const ffmpegStdout = "pipe:1"
+
+// ffmpegFormat returns FFmpeg's muxer name for a container format.
+func ffmpegFormat(format ContainerFormat) string {
+	switch format {
+	case FormatMKV:
+		return "matroska"
+	case FormatTS:
+		return "mpegts"
+	default:
+		return string(format)
+	}
+}
+
+// writeFFMetadata writes chapters in FFmpeg's metadata file format.
+func writeFFMetadata(path string, chapters []Chapter) error {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for _, ch := range chapters {
+		end := ch.End
+		if end <= ch.Start {
+			end = ch.Start + time.Millisecond
 		}
-		return err
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			ch.Start.Milliseconds(), end.Milliseconds(), ffmetaEscaper.Replace(ch.Title))
 	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
 
-	return nil
+// ffmetaEscaper escapes the characters FFmpeg's metadata format treats
+// as syntax with a backslash.
+var ffmetaEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+// formatSeconds formats a duration as an FFmpeg time value in seconds.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
 }
 
 // binaryCopy copies a file.
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFFMetadataEscapes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chapters.txt")
+	chapters := []Chapter{{Title: "Ad; id=7 #2\\x\nnext", Start: time.Second, End: 2 * time.Second}}
+	if err := writeFFMetadata(path, chapters); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `title=Ad\; id\=7 \#2\\x\` + "\nnext\n"
+	if !strings.HasSuffix(string(data), want) {
+		t.Errorf("metadata = %q, want it to end with %q", data, want)
+	}
+}
+
+func TestFFmpegLogStreamsLines(t *testing.T) {
+	var logged bytes.Buffer
+	m := &AutoMuxer{log: slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))}
+	out := m.ffmpegOutput(context.Background())
+
+	out.Write([]byte("Input #0, mpegts\nframe=  10 fps"))
+	if n := strings.Count(logged.String(), "\n"); n != 1 {
+		t.Fatalf("logged %d lines before the second ended, want 1:\n%s", n, logged.String())
+	}
+	out.Write([]byte("=25\rframe=  20 fps=25\n"))
+	for _, line := range []string{"Input #0, mpegts", "frame=  10 fps=25", "frame=  20 fps=25"} {
+		if !strings.Contains(logged.String(), line) {
+			t.Errorf("%q not logged:\n%s", line, logged.String())
+		}
+	}
+	if got := out.String(); got != "Input #0, mpegts\nframe=  10 fps=25\rframe=  20 fps=25" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestFFmpegLogKeepsTail(t *testing.T) {
+	m := &AutoMuxer{log: slog.New(slog.DiscardHandler)}
+	out := m.ffmpegOutput(context.Background())
+
+	for i := range 10000 {
+		fmt.Fprintf(out, "frame=%6d fps=25\r", i)
+	}
+	out.Write([]byte("Error while muxing: disk full\n"))
+
+	got := out.String()
+	if len(got) > ffmpegTailSize+8 {
+		t.Errorf("kept %d bytes, want about %d", len(got), ffmpegTailSize)
+	}
+	if !strings.HasPrefix(got, "...\nframe=") || !strings.HasSuffix(got, "Error while muxing: disk full") {
+		t.Errorf("String() = %q...%q", got[:20], got[len(got)-40:])
+	}
+}
@@ -0,0 +1,381 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// errStreamUnsupported is returned by MuxStream when the muxer cannot
+// assemble tracks while downloading; the caller falls back to Mux.
+var errStreamUnsupported = errors.New("streaming mux not supported")
+
+// StreamMuxer is implemented by muxers that can assemble media tracks while
+// segments are still downloading.
+type StreamMuxer interface {
+	MuxStream(ctx context.Context, tracks []*models.Track, outputPath string, format ContainerFormat) (*MuxStream, error)
+}
+
+// isMediaTrack reports whether a track is muxed into the output file, as
+// opposed to subtitles and thumbnails which are saved alongside it.
+func isMediaTrack(t *models.Track) bool {
+	return !t.IsSubtitle() && !t.IsThumbnail()
+}
+
+// startMuxStream starts muxing the selected media tracks while they
+// download. It returns nil if the muxer cannot stream.
+func (e *Engine) startMuxStream(ctx context.Context, outputPath string, format ContainerFormat) (*MuxStream, error) {
+	sm, ok := e.muxer.(StreamMuxer)
+	if !ok {
+		return nil, nil
+	}
+	media := slices.DeleteFunc(slices.Clone(e.SelectedTracks), func(t *models.Track) bool { return !isMediaTrack(t) })
+	if len(media) == 0 {
+		return nil, nil
+	}
+
+	stream, err := sm.MuxStream(ctx, media, outputPath, format)
+	if errors.Is(err, errStreamUnsupported) {
+		e.log.DebugContext(ctx, "pipeline muxing not supported here, muxing after download")
+		return nil, nil
+	}
+	return stream, err
+}
+
+// segmentState is the reassembly state of one segment.
+type segmentState uint8
+
+const (
+	segmentPending segmentState = iota
+	segmentReady
+	segmentFailed
+)
+
+// trackStream writes one track's segments to w in playlist order. Segments
+// may finish in any order; each is written once every earlier segment has
+// been written or has failed, and its file is deleted right after.
+type trackStream struct {
+	track     *models.Track
+	w         io.WriteCloser
+	positions map[int]int // segment index -> position in track.Segments
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	state   []segmentState
+	next    int
+	aborted bool
+}
+
+func newTrackStream(track *models.Track, w io.WriteCloser) *trackStream {
+	s := &trackStream{
+		track:     track,
+		w:         w,
+		positions: make(map[int]int, len(track.Segments)),
+		state:     make([]segmentState, len(track.Segments)),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	for i, seg := range track.Segments {
+		s.positions[seg.Index] = i
+	}
+	return s
+}
+
+// mark records that a segment finished downloading or failed.
+func (s *trackStream) mark(index int, state segmentState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pos, ok := s.positions[index]; ok {
+		s.state[pos] = state
+		s.cond.Broadcast()
+	}
+}
+
+// abort stops run at the next segment boundary.
+func (s *trackStream) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = true
+	s.cond.Broadcast()
+}
+
+// take blocks until the next segment in order is resolved. It returns
+// ok=false once all segments are written or the stream was aborted.
+func (s *trackStream) take() (seg *models.Segment, state segmentState, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.next < len(s.state) && s.state[s.next] == segmentPending && !s.aborted {
+		s.cond.Wait()
+	}
+	if s.aborted || s.next == len(s.state) {
+		return nil, 0, false
+	}
+	seg, state = s.track.Segments[s.next], s.state[s.next]
+	s.next++
+	return seg, state, true
+}
+
+// run writes the init segment and then each media segment as it becomes
+// available, until all segments are resolved or the stream is aborted.
+func (s *trackStream) run() error {
+	defer s.w.Close()
+	w := s.w
+
+	if init := s.track.InitSegment; init != nil && len(init.Data) > 0 {
+		if _, err := w.Write(init.Data); err != nil {
+			return fmt.Errorf("write init segment: %w", err)
+		}
+	}
+
+	for {
+		seg, state, ok := s.take()
+		if !ok {
+			return nil
+		}
+		if state != segmentReady {
+			continue // Failed segment: leave a gap
+		}
+		if err := writeSegment(w, seg); err != nil {
+			return fmt.Errorf("segment %d: %w", seg.Index, err)
+		}
+	}
+}
+
+// writeSegment copies a segment to w and releases its file or memory.
+func writeSegment(w io.Writer, seg *models.Segment) error {
+	if seg.FilePath == "" {
+		_, err := w.Write(seg.Data)
+		seg.Data = nil
+		return err
+	}
+
+	f, err := os.Open(seg.FilePath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	os.Remove(seg.FilePath)
+	seg.FilePath = ""
+	return nil
+}
+
+// MuxStream assembles media tracks into an output while they download.
+type MuxStream struct {
+	streams map[string]*trackStream
+	errs    chan error
+	wait    func() error // Waits for the muxer process after all streams end
+	cancel  context.CancelFunc
+	output  string // Removed if the stream is aborted
+}
+
+// Done tells the stream that a segment is downloaded and ready to write.
+func (s *MuxStream) Done(trackID string, index int) {
+	if ts, ok := s.streams[trackID]; ok {
+		ts.mark(index, segmentReady)
+	}
+}
+
+// Failed tells the stream to skip a segment that could not be downloaded.
+func (s *MuxStream) Failed(trackID string, index int) {
+	if ts, ok := s.streams[trackID]; ok {
+		ts.mark(index, segmentFailed)
+	}
+}
+
+// Close waits for every segment to be written and the output to be
+// finalized. All segments must have been reported as done or failed.
+func (s *MuxStream) Close() error {
+	var errs []error
+	for range s.streams {
+		if err := <-s.errs; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.wait != nil {
+		if err := s.wait(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.cancel()
+	return errors.Join(errs...)
+}
+
+// Abort stops muxing and removes the incomplete output.
+func (s *MuxStream) Abort() {
+	for _, ts := range s.streams {
+		ts.abort()
+	}
+	s.cancel()
+	for range s.streams {
+		<-s.errs
+	}
+	if s.wait != nil {
+		s.wait()
+	}
+	if s.output != "" {
+		os.Remove(s.output)
+	}
+}
+
+// start launches one writer goroutine per track.
+func (s *MuxStream) start() {
+	s.errs = make(chan error, len(s.streams))
+	for _, ts := range s.streams {
+		go func() {
+			err := ts.run()
+			if err != nil {
+				// Unblock the muxer; the remaining segments stay on disk
+				s.cancel()
+				err = fmt.Errorf("track %s: %w", ts.track.ID, err)
+			}
+			s.errs <- err
+		}()
+	}
+}
+
+// MuxStream starts muxing media tracks while they download. With FFmpeg,
+// each track is piped into its own FFmpeg input; otherwise a single track
+// is concatenated straight into the output, and several tracks are an
+// error. If the muxer has a writer, the output goes there instead of to
+// outputPath.
+func (m *AutoMuxer) MuxStream(ctx context.Context, tracks []*models.Track, outputPath string, format ContainerFormat) (*MuxStream, error) {
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no tracks to mux")
+	}
+
+	if m.writer == nil {
+		var err error
+		if outputPath, err = resolveOutputPath(outputPath, format); err != nil {
+			return nil, err
+		}
+	}
+
+	if !m.useFFmpeg() {
+		// Concatenating would keep one track and silently drop the rest
+		if len(tracks) > 1 {
+			return nil, fmt.Errorf("FFmpeg required to mux %d tracks while downloading; select one track or install FFmpeg", len(tracks))
+		}
+		return m.concatStream(tracks[0], outputPath)
+	}
+
+	// FFmpeg reads each track from an inherited pipe (fd 3, 4, ...)
+	if runtime.GOOS == "windows" {
+		return nil, errStreamUnsupported
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream := &MuxStream{
+		streams: make(map[string]*trackStream, len(tracks)),
+		cancel:  cancel,
+	}
+
+	inputs := make([]string, len(tracks))
+	readers := make([]*os.File, len(tracks))
+	cleanup := func() {
+		for _, r := range readers {
+			if r != nil {
+				r.Close()
+			}
+		}
+		for _, ts := range stream.streams {
+			ts.w.Close()
+		}
+		cancel()
+	}
+	for i, t := range tracks {
+		r, w, err := os.Pipe()
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("create pipe: %w", err)
+		}
+		readers[i] = r
+		inputs[i] = fmt.Sprintf("pipe:%d", 3+i)
+		stream.streams[t.ID] = newTrackStream(t, w)
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(outputPath), "."+string(format))
+	metaPath, err := m.writeChapters(baseName, format)
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	output := outputPath
+	if m.writer != nil {
+		output = ffmpegStdout
+	}
+	cmd := m.ffmpegCommand(ctx, inputs, metaPath, tracks, output, format)
+	cmd.ExtraFiles = readers
+	cmd.Stdout = m.writer
+	stderr := m.ffmpegOutput(ctx)
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		if metaPath != "" {
+			os.Remove(metaPath)
+		}
+		return nil, fmt.Errorf("start ffmpeg: %w", err)
+	}
+	// The child has its own copies of the read ends
+	for _, r := range readers {
+		r.Close()
+	}
+
+	if m.writer == nil {
+		stream.output = outputPath
+	}
+	stream.wait = func() error {
+		err := cmd.Wait()
+		if metaPath != "" {
+			os.Remove(metaPath)
+		}
+		if err != nil {
+			return fmt.Errorf("ffmpeg: %w: %s", err, stderr)
+		}
+		return nil
+	}
+	stream.start()
+	return stream, nil
+}
+
+// concatStream writes a track's init and media segments, in order, to the
+// output file or writer.
+func (m *AutoMuxer) concatStream(track *models.Track, outputPath string) (*MuxStream, error) {
+	var out io.WriteCloser
+	if m.writer != nil {
+		out = nopWriteCloser{m.writer}
+	} else {
+		f, err := os.Create(outputPath)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+
+	stream := &MuxStream{
+		streams: map[string]*trackStream{track.ID: newTrackStream(track, out)},
+		cancel:  func() {},
+	}
+	if m.writer == nil {
+		stream.output = outputPath
+	}
+	stream.start()
+	return stream, nil
+}
+
+// nopWriteCloser keeps a caller's writer open when the stream ends.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestMuxStreamReordersSegments(t *testing.T) {
+	dir := t.TempDir()
+	track := &models.Track{ID: "v", Type: models.TrackVideo, InitSegment: &models.Segment{Index: -1, Data: []byte("I")}}
+	for i := range 4 {
+		path := filepath.Join(dir, fmt.Sprintf("v_%05d.seg", i))
+		if err := os.WriteFile(path, []byte{byte('a' + i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		track.Segments = append(track.Segments, &models.Segment{Index: i + 10, FilePath: path})
+	}
+
+	m := &AutoMuxer{backend: "binary", tempDir: dir, log: slog.New(slog.DiscardHandler)}
+	out := filepath.Join(dir, "out.ts")
+	stream, err := m.MuxStream(context.Background(), []*models.Track{track}, out, FormatTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Finish out of order; segment 12 fails and leaves a gap
+	stream.Done("v", 13)
+	stream.Done("v", 11)
+	stream.Failed("v", 12)
+	stream.Done("v", 10)
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "Iabd" {
+		t.Errorf("output = %q, want %q", data, "Iabd")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "v_00000.seg")); !os.IsNotExist(err) {
+		t.Error("written segment file was not deleted")
+	}
+}
+
+func TestMuxStreamToWriter(t *testing.T) {
+	track := &models.Track{ID: "a", Type: models.TrackAudio, Segments: []*models.Segment{
+		{Index: 0, Data: []byte("one")},
+		{Index: 1, Data: []byte("two")},
+	}}
+
+	var buf bytes.Buffer
+	m := &AutoMuxer{backend: "binary", writer: &buf, log: slog.New(slog.DiscardHandler)}
+	stream, err := m.MuxStream(context.Background(), []*models.Track{track}, "", FormatMP4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Done("a", 1)
+	stream.Done("a", 0)
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "onetwo" {
+		t.Errorf("writer got %q, want %q", buf.String(), "onetwo")
+	}
+}
+
+func TestMuxStreamWithoutFFmpegRejectsTracks(t *testing.T) {
+	tracks := []*models.Track{
+		{ID: "v", Type: models.TrackVideo},
+		{ID: "a", Type: models.TrackAudio},
+	}
+	var buf bytes.Buffer
+	m := &AutoMuxer{backend: "binary", writer: &buf, log: slog.New(slog.DiscardHandler)}
+	if _, err := m.MuxStream(context.Background(), tracks, "", FormatTS); err == nil {
+		t.Error("two tracks streamed without FFmpeg; the audio would be dropped")
+	}
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// refreshRetryInterval is how long a failed refresh blocks the next one,
+// unless a segment succeeds first.
+var refreshRetryInterval = time.Minute
+
+// URLFetcher returns fresh URLs for segments whose signed URLs expired.
+type URLFetcher func(ctx context.Context) (map[*models.Segment]string, error)
+
+// urlRefresher renews expired segment URLs. One refresh runs at a time;
+// workers that get 401/403 meanwhile wait for it and then retry.
+type urlRefresher struct {
+	fetch     URLFetcher
+	threshold int // 401/403 responses that trigger a refresh
+
+	urlMu sync.RWMutex // Guards Segment.URL of the downloaded tracks
+
+	mu         sync.Mutex
+	generation int           // Number of successful refreshes
+	failures   int           // 401/403 responses since the last refresh
+	progressed bool          // A segment succeeded since the last refresh
+	failedAt   time.Time     // When the last refresh failed, zero once a segment succeeds
+	running    chan struct{} // Closed when the current refresh ends
+}
+
+// segmentURL returns a segment's current URL.
+func (r *urlRefresher) segmentURL(seg *models.Segment) string {
+	r.urlMu.RLock()
+	defer r.urlMu.RUnlock()
+	return seg.URL
+}
+
+// Generation identifies the URLs in use. Read it before a request.
+func (r *urlRefresher) Generation() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.generation
+}
+
+// succeeded records that a segment downloaded with the current URLs.
+func (r *urlRefresher) succeeded() {
+	r.mu.Lock()
+	r.progressed = true
+	r.failedAt = time.Time{}
+	r.mu.Unlock()
+}
+
+// expired handles a 401/403 for a request made with URLs from generation
+// gen. It returns true if the URLs were refreshed since, and the segment
+// should be tried again. A refresh is only attempted once threshold
+// responses have failed, and not again until a segment succeeds with the
+// refreshed URLs, so a permanent 403 can't loop. A failed refresh, e.g.
+// when the manifest is forbidden too, is not retried until a segment
+// succeeds or refreshRetryInterval passes.
+func (r *urlRefresher) expired(ctx context.Context, gen int) (bool, error) {
+	r.mu.Lock()
+	if r.generation != gen {
+		r.mu.Unlock()
+		return true, nil
+	}
+	if ch := r.running; ch != nil {
+		r.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		return r.Generation() != gen, nil
+	}
+	r.failures++
+	if r.failures < r.threshold || (r.generation > 0 && !r.progressed) ||
+		(!r.failedAt.IsZero() && time.Since(r.failedAt) < refreshRetryInterval) {
+		r.mu.Unlock()
+		return false, nil
+	}
+	ch := make(chan struct{})
+	r.running = ch
+	r.mu.Unlock()
+
+	urls, err := r.fetch(ctx)
+	if err == nil {
+		r.urlMu.Lock()
+		for seg, url := range urls {
+			seg.URL = url
+		}
+		r.urlMu.Unlock()
+	}
+
+	r.mu.Lock()
+	r.running = nil
+	if err == nil {
+		r.generation++
+		r.failures = 0
+		r.progressed = false
+		r.failedAt = time.Time{}
+	} else {
+		r.failures = 0
+		r.failedAt = time.Now()
+	}
+	r.mu.Unlock()
+	close(ch)
+	return err == nil, err
+}
+
+// isAuthError reports whether err is a 401 or 403 response, the usual
+// sign of an expired signed URL.
+func isAuthError(err error) bool {
+	var se *httpclient.StatusError
+	return errors.As(err, &se) &&
+		(se.StatusCode == http.StatusUnauthorized || se.StatusCode == http.StatusForbidden)
+}
+
+// refreshSegmentURLs fetches the manifest again and returns new URLs for
+// the selected tracks' segments, matching tracks by ID and segments by
+// index. The manifest URL comes from cfg.RefreshURL if set.
+func (e *Engine) refreshSegmentURLs(ctx context.Context) (map[*models.Segment]string, error) {
+	manifestURL := e.cfg.URL
+	if e.cfg.RefreshURL != nil {
+		u, err := e.cfg.RefreshURL(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("refresh callback: %w", err)
+		}
+		if u != "" {
+			manifestURL = u
+		}
+	}
+	e.log.DebugContext(ctx, "segment URLs expired, fetching manifest again")
+
+	manifest, err := e.ParseManifest(ctx, manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("refresh manifest: %w", err)
+	}
+
+	fresh := make(map[string]*models.Track, len(manifest.Tracks))
+	for _, t := range manifest.Tracks {
+		fresh[t.ID] = t
+	}
+
+	urls := make(map[*models.Segment]string)
+	for _, track := range e.SelectedTracks {
+		nt := fresh[track.ID]
+		if nt == nil {
+			continue
+		}
+		if nt.MediaPlaylistURL != "" && len(nt.Segments) == 0 {
+			if err := e.LoadTrackSegments(ctx, nt); err != nil {
+				return nil, fmt.Errorf("refresh %s: %w", track.ID, err)
+			}
+		}
+
+		byIndex := make(map[int]string, len(nt.Segments))
+		for _, seg := range nt.Segments {
+			byIndex[seg.Index] = seg.URL
+		}
+		for _, seg := range track.Segments {
+			if url, ok := byIndex[seg.Index]; ok {
+				urls[seg] = url
+			}
+		}
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("refreshed manifest has none of the downloaded tracks")
+	}
+
+	e.log.DebugContext(ctx, "refreshed segment URLs", "segments", len(urls))
+	return urls, nil
+}
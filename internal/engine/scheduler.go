@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// Scheduler decides the order in which segments are queued for download.
+type Scheduler interface {
+	// Schedule returns a task for every segment of tracks, in download order.
+	Schedule(tracks []*models.Track) []*SegmentTask
+}
+
+// Schedule names accepted by NewScheduler.
+const (
+	ScheduleSequential     = "sequential"      // Track by track, each in index order
+	ScheduleInterleaved    = "interleaved"     // All tracks by presentation time
+	ScheduleParallelTracks = "parallel-tracks" // One segment of each track in turn
+)
+
+// NewScheduler returns the scheduler with the given name.
+func NewScheduler(name string) (Scheduler, error) {
+	switch name {
+	case ScheduleSequential:
+		return SequentialScheduler{}, nil
+	case ScheduleInterleaved:
+		return InterleavedScheduler{}, nil
+	case ScheduleParallelTracks:
+		return ParallelTracksScheduler{}, nil
+	}
+	return nil, fmt.Errorf("unknown schedule %q (want %s, %s or %s)",
+		name, ScheduleSequential, ScheduleInterleaved, ScheduleParallelTracks)
+}
+
+// SequentialScheduler downloads tracks one after another.
+type SequentialScheduler struct{}
+
+func (SequentialScheduler) Schedule(tracks []*models.Track) []*SegmentTask {
+	var tasks []*SegmentTask
+	for _, track := range tracks {
+		for _, seg := range track.Segments {
+			tasks = append(tasks, &SegmentTask{Segment: seg, Track: track})
+		}
+	}
+	return tasks
+}
+
+// ParallelTracksScheduler takes one segment from each track in turn, so all
+// tracks advance at the same number of segments.
+type ParallelTracksScheduler struct{}
+
+func (ParallelTracksScheduler) Schedule(tracks []*models.Track) []*SegmentTask {
+	var tasks []*SegmentTask
+	for i := 0; ; i++ {
+		added := false
+		for _, track := range tracks {
+			if i < len(track.Segments) {
+				tasks = append(tasks, &SegmentTask{Segment: track.Segments[i], Track: track})
+				added = true
+			}
+		}
+		if !added {
+			return tasks
+		}
+	}
+}
+
+// InterleavedScheduler orders segments of all tracks by presentation time
+// (Segment.Start), so a partly downloaded output has every track up to
+// about the same point. Ties keep the track order.
+type InterleavedScheduler struct{}
+
+func (InterleavedScheduler) Schedule(tracks []*models.Track) []*SegmentTask {
+	tasks := SequentialScheduler{}.Schedule(tracks)
+	slices.SortStableFunc(tasks, func(a, b *SegmentTask) int {
+		return cmp.Compare(a.Segment.Start, b.Segment.Start)
+	})
+	return tasks
+}
+
+// scheduler returns the configured scheduler. Without an explicit schedule,
+// ParallelTracks selects parallel-tracks, and pipeline or writer output
+// (which is written in order while downloading) uses interleaved.
+func (e *Engine) scheduler() (Scheduler, error) {
+	if e.sched != nil {
+		return e.sched, nil
+	}
+	name := e.cfg.Schedule
+	switch {
+	case name != "":
+	case e.cfg.ParallelTracks:
+		name = ScheduleParallelTracks
+	case e.cfg.Pipeline || e.cfg.Writer != nil:
+		name = ScheduleInterleaved
+	default:
+		name = ScheduleSequential
+	}
+	return NewScheduler(name)
+}
+
+// SetScheduler sets a custom segment download order, overriding the
+// configured schedule.
+func (e *Engine) SetScheduler(s Scheduler) {
+	e.sched = s
+}
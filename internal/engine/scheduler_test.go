@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func scheduleTracks() []*models.Track {
+	track := func(id string, n int, dur time.Duration) *models.Track {
+		t := &models.Track{ID: id}
+		for i := range n {
+			t.Segments = append(t.Segments, &models.Segment{Index: i, Duration: dur})
+		}
+		assignStartTimes(t.Segments)
+		return t
+	}
+	return []*models.Track{
+		track("v", 3, 2*time.Second),
+		track("a", 2, 6*time.Second),
+	}
+}
+
+func scheduleOrder(tasks []*SegmentTask) string {
+	parts := make([]string, len(tasks))
+	for i, t := range tasks {
+		parts[i] = fmt.Sprintf("%s%d", t.Track.ID, t.Segment.Index)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestSchedulers(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{ScheduleSequential, "v0 v1 v2 a0 a1"},
+		{ScheduleParallelTracks, "v0 a0 v1 a1 v2"},
+		{ScheduleInterleaved, "v0 a0 v1 v2 a1"}, // starts 0 0 2 4 6
+	}
+	for _, tt := range tests {
+		sched, err := NewScheduler(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scheduleOrder(sched.Schedule(scheduleTracks())); got != tt.want {
+			t.Errorf("%s: order %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := NewScheduler("random"); err == nil {
+		t.Error("unknown schedule accepted")
+	}
+}
@@ -92,9 +92,10 @@ func languageMatches(trackLang, wantLang string) bool {
 
 // TrackSelector provides smart track selection from a list of tracks.
 type TrackSelector struct {
-	Videos    []*models.Track
-	Audios    []*models.Track
-	Subtitles []*models.Track
+	Videos     []*models.Track
+	Audios     []*models.Track
+	Subtitles  []*models.Track
+	Thumbnails []*models.Track
 }
 
 // NewTrackSelector categorizes tracks by type.
@@ -103,6 +104,8 @@ func NewTrackSelector(tracks []*models.Track) *TrackSelector {
 
 	for _, t := range tracks {
 		switch {
+		case t.IsThumbnail():
+			ts.Thumbnails = append(ts.Thumbnails, t)
 		case t.IsSubtitle():
 			ts.Subtitles = append(ts.Subtitles, t)
 		case t.IsAudio():
@@ -130,13 +133,14 @@ func NewTrackSelector(tracks []*models.Track) *TrackSelector {
 	}
 	sortByBandwidth(ts.Videos)
 	sortByBandwidth(ts.Audios)
+	sortByBandwidth(ts.Thumbnails)
 
 	return ts
 }
 
 // trackExpr represents a parsed track expression like "a:en,ar!" or "v:-1080p[>2M]"
 type trackExpr struct {
-	trackType    string   // "v", "a", "s" or empty
+	trackType    string   // "v", "a", "s", "t" or empty
 	values       []string // languages, resolutions, etc.
 	required     bool     // ! modifier - fail if not found
 	includeUnd   bool     // ? modifier - include undefined tracks
@@ -331,6 +335,7 @@ func (ts *TrackSelector) Select(selector string) ([]*models.Track, error) {
 		all = append(all, ts.Videos...)
 		all = append(all, ts.Audios...)
 		all = append(all, ts.Subtitles...)
+		all = append(all, ts.Thumbnails...)
 		return all, nil
 
 	case "all-video":
@@ -342,6 +347,9 @@ func (ts *TrackSelector) Select(selector string) ([]*models.Track, error) {
 	case "all-subs", "all-subtitles":
 		return ts.Subtitles, nil
 
+	case "all-thumbs", "all-thumbnails":
+		return ts.Thumbnails, nil
+
 	case "best", "bv+ba", "best-video+best-audio":
 		var selected []*models.Track
 		if len(ts.Videos) > 0 {
@@ -462,6 +470,8 @@ func (ts *TrackSelector) selectByExpression(expr *trackExpr) ([]*models.Track, e
 		pool = ts.Audios
 	case "s", "sub", "subtitle":
 		pool = ts.Subtitles
+	case "t", "thumb", "thumbnail":
+		pool = ts.Thumbnails
 	default:
 		// Auto-detect based on expression content
 		if expr.resMax > 0 || expr.resMin > 0 || expr.resUpTo {
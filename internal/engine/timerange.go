@@ -0,0 +1,60 @@
+package engine
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// assignStartTimes sets each segment's Start from cumulative segment
+// durations so every track is placed on the same timeline.
+func assignStartTimes(segments []*models.Segment) {
+	var pos time.Duration
+	for _, seg := range segments {
+		seg.Start = pos
+		pos += seg.Duration
+	}
+}
+
+// clipSegments returns the segments overlapping [start, end).
+// An end of 0 means until the end of the track.
+func clipSegments(segments []*models.Segment, start, end time.Duration) []*models.Segment {
+	var kept []*models.Segment
+	for _, seg := range segments {
+		if seg.Start+seg.Duration <= start {
+			continue
+		}
+		if end > 0 && seg.Start >= end {
+			break
+		}
+		kept = append(kept, seg)
+	}
+	return kept
+}
+
+// trackDuration returns the sum of all segment durations.
+func trackDuration(segments []*models.Segment) time.Duration {
+	var total time.Duration
+	for _, seg := range segments {
+		total += seg.Duration
+	}
+	return total
+}
+
+// clipTrack restricts a track to the configured time range.
+// Tracks without segment durations (e.g. single-file subtitles) are kept whole.
+func (e *Engine) clipTrack(track *models.Track) error {
+	if !e.cfg.HasTimeRange() || trackDuration(track.Segments) == 0 {
+		return nil
+	}
+
+	kept := clipSegments(track.Segments, e.cfg.StartTime, e.cfg.EndTime)
+	if len(kept) == 0 {
+		return fmt.Errorf("time range starts after the end of track %s (%s)", track.ID, trackDuration(track.Segments))
+	}
+	e.log.Debug("clipped track", "track", track.ID, "kept", len(kept), "segments", len(track.Segments))
+	track.Segments = kept
+	track.ClipStart = e.cfg.StartTime - kept[0].Start
+	return nil
+}
@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func makeSegments(n int, dur time.Duration) []*models.Segment {
+	segs := make([]*models.Segment, n)
+	for i := range segs {
+		segs[i] = &models.Segment{Index: i, Duration: dur}
+	}
+	assignStartTimes(segs)
+	return segs
+}
+
+func TestClipSegments(t *testing.T) {
+	segs := makeSegments(10, 6*time.Second) // 0-60s
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		wantFirst  int
+		wantCount  int
+		wantOffset time.Duration
+	}{
+		{"full", 0, 0, 0, 10, 0},
+		{"aligned", 12 * time.Second, 24 * time.Second, 2, 2, 12 * time.Second},
+		{"mid-segment", 13 * time.Second, 25 * time.Second, 2, 3, 12 * time.Second},
+		{"open end", 50 * time.Second, 0, 8, 2, 48 * time.Second},
+		{"past end", 70 * time.Second, 0, 0, 0, 0},
+	}
+
+	for _, tt := range tests {
+		kept := clipSegments(segs, tt.start, tt.end)
+		if len(kept) != tt.wantCount {
+			t.Errorf("%s: got %d segments, want %d", tt.name, len(kept), tt.wantCount)
+			continue
+		}
+		if len(kept) == 0 {
+			continue
+		}
+		if kept[0].Index != tt.wantFirst {
+			t.Errorf("%s: first segment %d, want %d", tt.name, kept[0].Index, tt.wantFirst)
+		}
+		if kept[0].Start != tt.wantOffset {
+			t.Errorf("%s: first start %s, want %s", tt.name, kept[0].Start, tt.wantOffset)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+// ErrInvalidSegment is returned for a segment body that is truncated or
+// doesn't look like media, e.g. an HTML error page served with 200. The
+// segment is downloaded again.
+var ErrInvalidSegment = errors.New("invalid segment")
+
+// ErrRangeIgnored is returned when a byte range segment is answered with
+// 200 and the whole resource. Asking again gets the same reply, so the
+// segment is not retried.
+var ErrRangeIgnored = errors.New("server ignored the byte range")
+
+// sniffLen is how much of a segment is read to check its format.
+const sniffLen = 512
+
+// tsPacketSize is the size of an MPEG-TS packet.
+const tsPacketSize = 188
+
+// Top-level ISO BMFF boxes an fMP4 segment may start with
+var mp4Boxes = []string{"styp", "moof", "sidx", "emsg", "prft", "ftyp", "moov", "mdat", "free", "skip", "uuid", "ssix"}
+
+// checkLength returns an ErrInvalidSegment error if a response body of n
+// bytes doesn't match its Content-Length, or a byte range segment's total
+// of size bytes doesn't match the requested range.
+func checkLength(resp *http.Response, byteRange *models.ByteRange, n, size int64) error {
+	if resp.ContentLength >= 0 && n != resp.ContentLength {
+		return fmt.Errorf("%w: got %d bytes, Content-Length is %d", ErrInvalidSegment, n, resp.ContentLength)
+	}
+	if byteRange != nil && byteRange.End >= byteRange.Start {
+		if want := byteRange.End - byteRange.Start + 1; size != want {
+			return fmt.Errorf("%w: got %d bytes for a %d byte range", ErrInvalidSegment, size, want)
+		}
+	}
+	return nil
+}
+
+// sniffFile checks the format of the segment at path, see sniffSegment.
+func sniffFile(path string, track *models.Track) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	defer f.Close()
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	return sniffSegment(head[:n], track)
+}
+
+// sniffSegment returns an ErrInvalidSegment error unless the start of a
+// segment looks like the track's kind of media: MPEG-TS, fMP4, WebM or
+// packed audio for audio and video, WebVTT, TTML or fMP4 for subtitles, and an
+// image for image tiles.
+func sniffSegment(head []byte, track *models.Track) error {
+	if len(head) == 0 {
+		return fmt.Errorf("%w: empty body", ErrInvalidSegment)
+	}
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if isHTML(text) {
+		return fmt.Errorf("%w: got an HTML page", ErrInvalidSegment)
+	}
+
+	switch {
+	case track.IsImage():
+		if isImage(head) {
+			return nil
+		}
+	case track.IsSubtitle():
+		if bytes.HasPrefix(text, []byte("WEBVTT")) || isTTML(text) || isMP4(head) ||
+			(len(text) > 0 && text[0] >= '0' && text[0] <= '9') { // SRT cue number
+			return nil
+		}
+	default:
+		if isTS(head) || isMP4(head) || isWebM(head) || isPackedAudio(head) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unrecognized %s data starting with %q", ErrInvalidSegment, track.Type, head[:min(len(head), 8)])
+}
+
+// isHTML reports whether text starts like an HTML page.
+func isHTML(text []byte) bool {
+	lower := bytes.ToLower(text[:min(len(text), 16)])
+	for _, prefix := range []string{"<!doctype html", "<html", "<head", "<body"} {
+		if bytes.HasPrefix(lower, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTS reports whether data starts with MPEG-TS packets: a sync byte every
+// 188 bytes, as far as data goes.
+func isTS(data []byte) bool {
+	if data[0] != 0x47 {
+		return false
+	}
+	for i := tsPacketSize; i < len(data); i += tsPacketSize {
+		if data[i] != 0x47 {
+			return false
+		}
+	}
+	return true
+}
+
+// isMP4 reports whether data starts with an ISO BMFF box.
+func isMP4(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	size := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	if size != 1 && size < 8 { // 1 = 64-bit size follows
+		return false
+	}
+	for _, box := range mp4Boxes {
+		if string(data[4:8]) == box {
+			return true
+		}
+	}
+	return false
+}
+
+// Matroska elements a WebM segment may start with: the EBML header of an
+// init segment, a Segment, or the Cluster of a media segment
+var webmElements = [][]byte{{0x1A, 0x45, 0xDF, 0xA3}, {0x18, 0x53, 0x80, 0x67}, {0x1F, 0x43, 0xB6, 0x75}}
+
+// isWebM reports whether data starts with a WebM (Matroska) element.
+func isWebM(data []byte) bool {
+	for _, id := range webmElements {
+		if bytes.HasPrefix(data, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPackedAudio reports whether data starts like HLS packed audio: an ID3
+// tag, or an ADTS, MP3 or AC-3 frame.
+func isPackedAudio(data []byte) bool {
+	switch {
+	case bytes.HasPrefix(data, []byte("ID3")):
+		return true
+	case len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0: // ADTS, MP3
+		return true
+	case len(data) >= 2 && data[0] == 0x0B && data[1] == 0x77: // AC-3, E-AC-3
+		return true
+	}
+	return false
+}
+
+// isTTML reports whether text is XML with a TTML <tt> root.
+func isTTML(text []byte) bool {
+	return bytes.HasPrefix(text, []byte("<")) &&
+		(bytes.Contains(text, []byte("<tt ")) || bytes.Contains(text, []byte("<tt>")) || bytes.Contains(text, []byte(":tt ")))
+}
+
+// isImage reports whether data starts like a JPEG, PNG or WebP image.
+func isImage(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("\xFF\xD8\xFF")) ||
+		bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")) ||
+		(len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP")
+}
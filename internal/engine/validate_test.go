@@ -0,0 +1,167 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestSniffSegment(t *testing.T) {
+	ts := bytes.Repeat(append([]byte{0x47}, make([]byte, tsPacketSize-1)...), 3)
+	badTS := bytes.Clone(ts)
+	badTS[tsPacketSize] = 0
+	moof := []byte("\x00\x00\x00\x18moof\x00\x00\x00\x10mfhd")
+
+	video := &models.Track{Type: models.TrackVideo}
+	audio := &models.Track{Type: models.TrackAudio}
+	subs := &models.Track{Type: models.TrackSubtitle}
+	tiles := &models.Track{Type: models.TrackThumbnail, Codec: "jpeg"}
+
+	for _, tc := range []struct {
+		name  string
+		track *models.Track
+		data  []byte
+		ok    bool
+	}{
+		{"ts", video, ts, true},
+		{"ts out of sync", video, badTS, false},
+		{"fmp4", video, moof, true},
+		{"styp", audio, []byte("\x00\x00\x00\x10stypmsdh"), true},
+		{"webm init", video, []byte("\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01"), true},
+		{"webm cluster", audio, []byte("\x1F\x43\xB6\x75\x01\x00\x00\x00"), true},
+		{"packed aac", audio, []byte("ID3\x04\x00"), true},
+		{"adts", audio, []byte{0xFF, 0xF1, 0x50, 0x80}, true},
+		{"empty", video, nil, false},
+		{"html", video, []byte("\n<!DOCTYPE html><html><body>Not found</body></html>"), false},
+		{"xml error", video, []byte(`<?xml version="1.0"?><Error><Code>AccessDenied</Code></Error>`), false},
+		{"webvtt", subs, []byte("\xEF\xBB\xBFWEBVTT\n\n00:00.000 --> 00:01.000\nHi"), true},
+		{"ttml", subs, []byte(`<?xml version="1.0"?><tt xmlns="http://www.w3.org/ns/ttml">`), true},
+		{"stpp", subs, moof, true},
+		{"subtitle html", subs, []byte("<html><head>"), false},
+		{"jpeg tile", tiles, []byte("\xFF\xD8\xFF\xE0"), true},
+		{"tile html", tiles, []byte("<html>"), false},
+	} {
+		err := sniffSegment(tc.data, tc.track)
+		if tc.ok && err != nil {
+			t.Errorf("%s: rejected: %v", tc.name, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrInvalidSegment) {
+			t.Errorf("%s: accepted", tc.name)
+		}
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	resp := &http.Response{ContentLength: 100}
+	if err := checkLength(resp, nil, 100, 100); err != nil {
+		t.Errorf("matching length rejected: %v", err)
+	}
+	if err := checkLength(resp, nil, 60, 60); !errors.Is(err, ErrInvalidSegment) {
+		t.Error("truncated body accepted")
+	}
+
+	// A server ignoring Range sends the whole file
+	resp.ContentLength = -1
+	br := &models.ByteRange{Start: 1000, End: 1099}
+	if err := checkLength(resp, br, 100, 100); err != nil {
+		t.Errorf("byte range rejected: %v", err)
+	}
+	if err := checkLength(resp, br, 5000, 5000); !errors.Is(err, ErrInvalidSegment) {
+		t.Error("body larger than the byte range accepted")
+	}
+}
+
+func TestWorkerPoolRetriesInvalidSegment(t *testing.T) {
+	ts := bytes.Repeat(append([]byte{0x47}, make([]byte, tsPacketSize-1)...), 10)
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// Misconfigured CDN: error page with 200
+			w.Write([]byte("<html><body>Service unavailable</body></html>"))
+			return
+		}
+		w.Write(ts)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	pool := NewWorkerPool(1, srv.Client(), make(chan ProgressUpdate, 4))
+	pool.SetTempDir(dir)
+	pool.SetCheckpoint(NewCheckpoint(srv.URL, dir, nil))
+	pool.SetSegmentCheck(true)
+	pool.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond})
+	pool.Start(context.Background())
+
+	seg := &models.Segment{Index: 0, URL: srv.URL + "/seg.ts"}
+	pool.Submit(&SegmentTask{Segment: seg, Track: &models.Track{ID: "v", Type: models.TrackVideo}})
+	if gaps := pool.Wait(); len(gaps) > 0 {
+		t.Fatalf("segment failed: %v", gaps[0].Err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("%d requests, want 2", n)
+	}
+	data, err := os.ReadFile(seg.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ts) {
+		t.Errorf("segment has %d bytes, want the %d byte retry", len(data), len(ts))
+	}
+}
+
+func TestWorkerPoolRejectsIgnoredRange(t *testing.T) {
+	file := bytes.Repeat(append([]byte{0x47}, make([]byte, tsPacketSize-1)...), 10)
+	for _, tc := range []struct {
+		name  string
+		disk  bool
+		check bool
+	}{
+		{"disk", true, true},
+		{"disk without checks", true, false},
+		{"memory", false, true},
+		{"memory without checks", false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.Write(file) // Range header ignored
+			}))
+			defer srv.Close()
+
+			dir := t.TempDir()
+			pool := NewWorkerPool(1, srv.Client(), make(chan ProgressUpdate, 4))
+			if tc.disk {
+				pool.SetTempDir(dir)
+			}
+			pool.SetSegmentCheck(tc.check)
+			pool.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+			pool.Start(context.Background())
+
+			seg := &models.Segment{Index: 0, URL: srv.URL + "/seg.ts", ByteRange: &models.ByteRange{Start: tsPacketSize, End: 3*tsPacketSize - 1}}
+			pool.Submit(&SegmentTask{Segment: seg, Track: &models.Track{ID: "v", Type: models.TrackVideo}})
+			gaps := pool.Wait()
+			if len(gaps) != 1 || !errors.Is(gaps[0].Err, ErrRangeIgnored) {
+				t.Fatalf("gaps = %v, want one ErrRangeIgnored", gaps)
+			}
+			if n := requests.Load(); n != 1 {
+				t.Errorf("%d requests, want 1", n)
+			}
+			if seg.FilePath != "" || seg.Data != nil {
+				t.Error("whole-file reply was stored as the segment")
+			}
+			if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+				t.Errorf("temp dir not empty: %v", entries[0].Name())
+			}
+		})
+	}
+}
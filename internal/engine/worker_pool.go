@@ -3,14 +3,20 @@ package engine
 import (
 	"context"
 	"fmt"
+	"hash"
+	"hash/crc32"
 	"io"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/models"
 )
 
@@ -20,6 +26,8 @@ type SegmentTask struct {
 	Track   *models.Track
 	Headers map[string]string
 	DecFunc func(track *models.Track, segment *models.Segment) error
+
+	record SegmentRecord // The segment file, set by downloadToDisk
 }
 
 // WorkerPool manages concurrent segment downloads.
@@ -33,19 +41,25 @@ type WorkerPool struct {
 	wg        sync.WaitGroup
 	ctx       context.Context
 	cancel    context.CancelFunc
+	done      chan struct{} // Closed when all workers have exited
 
 	// Stats
 	completed  atomic.Int64
 	totalBytes atomic.Int64
 	failed     atomic.Int64
 	startTime  time.Time
-	errors     []error
-	errorsMu   sync.Mutex
+	gaps       []Gap // Segments that failed all retries
+	gapsMu     sync.Mutex
 
 	// Config
-	maxRetries    int
-	verbose       bool
-	onSegmentDone func(trackID string, index int) // Called after successful download
+	retry           httpclient.RetryPolicy
+	adaptive        *concurrencyController // nil = all workers download at once
+	refresher       *urlRefresher          // nil = expired URLs are not renewed
+	log             *slog.Logger
+	checkSegments   bool                                               // Validate body length and format
+	checkpoint      *Checkpoint                                        // Partial body state for resumable downloads
+	onSegmentDone   func(trackID string, index int, rec SegmentRecord) // Called after successful download
+	onSegmentFailed func(trackID string, index int)                    // Called when all retries failed
 }
 
 // NewWorkerPool creates a new worker pool.
@@ -55,8 +69,31 @@ func NewWorkerPool(workers int, client *http.Client, progressCh chan<- ProgressU
 		client:     client,
 		progressCh: progressCh,
 		taskQueue:  make(chan *SegmentTask, workers*4),
-		maxRetries: 5,
+		done:       make(chan struct{}),
+		retry:      httpclient.DefaultRetryPolicy(),
+		log:        slog.New(slog.DiscardHandler),
+	}
+}
+
+// SetAdaptive lets the pool tune how many workers download at once,
+// between minWorkers and the pool size, from observed throughput, errors
+// and latency. Must be called before Start.
+func (p *WorkerPool) SetAdaptive(minWorkers int) {
+	p.adaptive = newConcurrencyController(minWorkers, p.workers)
+}
+
+// SetURLRefresh renews segment URLs with fetch once threshold requests
+// have failed with 401/403, then retries the affected segments.
+func (p *WorkerPool) SetURLRefresh(threshold int, fetch URLFetcher) {
+	p.refresher = &urlRefresher{fetch: fetch, threshold: max(threshold, 1)}
+}
+
+// Concurrency returns the number of workers currently allowed to download.
+func (p *WorkerPool) Concurrency() int {
+	if p.adaptive != nil {
+		return p.adaptive.Limit()
 	}
+	return p.workers
 }
 
 // SetTempDir sets the directory for storing downloaded segments.
@@ -64,16 +101,39 @@ func (p *WorkerPool) SetTempDir(dir string) {
 	p.tempDir = dir
 }
 
-// SetVerbose enables verbose error logging.
-func (p *WorkerPool) SetVerbose(v bool) {
-	p.verbose = v
+// SetRetryPolicy sets how failed segment downloads are retried.
+func (p *WorkerPool) SetRetryPolicy(policy httpclient.RetryPolicy) {
+	p.retry = policy
 }
 
-// SetOnSegmentDone sets a callback for successful segment downloads.
-func (p *WorkerPool) SetOnSegmentDone(fn func(trackID string, index int)) {
+// SetLogger sets the logger for retries and concurrency changes.
+func (p *WorkerPool) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
+// SetSegmentCheck enables checking each segment body against its
+// Content-Length and byte range, and sniffing its format. Segments that
+// fail are retried.
+func (p *WorkerPool) SetSegmentCheck(enabled bool) {
+	p.checkSegments = enabled
+}
+
+// SetCheckpoint sets the checkpoint used to record partially downloaded segments.
+func (p *WorkerPool) SetCheckpoint(cp *Checkpoint) {
+	p.checkpoint = cp
+}
+
+// SetOnSegmentDone sets a callback for successful segment downloads. rec
+// describes the segment file when downloading to disk.
+func (p *WorkerPool) SetOnSegmentDone(fn func(trackID string, index int, rec SegmentRecord)) {
 	p.onSegmentDone = fn
 }
 
+// SetOnSegmentFailed sets a callback for segments that failed all retries.
+func (p *WorkerPool) SetOnSegmentFailed(fn func(trackID string, index int)) {
+	p.onSegmentFailed = fn
+}
+
 // Start launches the worker goroutines.
 func (p *WorkerPool) Start(ctx context.Context) {
 	p.ctx, p.cancel = context.WithCancel(ctx)
@@ -83,6 +143,28 @@ func (p *WorkerPool) Start(ctx context.Context) {
 		p.wg.Add(1)
 		go p.worker()
 	}
+	if p.adaptive != nil {
+		go p.adapt()
+	}
+}
+
+// adapt periodically re-tunes the adaptive concurrency limit.
+func (p *WorkerPool) adapt() {
+	ticker := time.NewTicker(adaptInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			if old, reason := p.adaptive.adjust(now); reason != "" {
+				p.log.Debug("concurrency changed", "from", old, "to", p.adaptive.Limit(), "reason", reason)
+			}
+		case <-p.ctx.Done():
+			p.adaptive.close()
+			return
+		case <-p.done:
+			return
+		}
+	}
 }
 
 // worker is the main download loop for each worker goroutine.
@@ -104,76 +186,315 @@ func (p *WorkerPool) worker() {
 
 // downloadSegment performs the actual HTTP download with retries.
 func (p *WorkerPool) downloadSegment(task *SegmentTask) {
-	var lastErr error
-
-	for attempt := 0; attempt < p.maxRetries; attempt++ {
-		if attempt > 0 {
-			// Exponential backoff: 500ms, 1s, 2s, 4s, 8s
-			backoff := time.Duration(1<<uint(attempt-1)) * 500 * time.Millisecond
-			select {
-			case <-time.After(backoff):
-			case <-p.ctx.Done():
-				p.sendProgress(task, 0, p.ctx.Err())
-				return
+	policy := p.retry.Logged(p.log, "track", task.Track.ID, "segment", task.Segment.Index)
+
+	var err error
+	for {
+		gen := 0
+		if p.refresher != nil {
+			gen = p.refresher.Generation()
+		}
+		err = policy.Do(p.ctx, func(int) error {
+			if p.adaptive == nil {
+				return p.download(task)
+			}
+			// Hold a slot per attempt, not while backing off
+			if !p.adaptive.acquire() {
+				return p.ctx.Err()
 			}
+			defer p.adaptive.release()
+			err := p.download(task)
+			p.adaptive.observe(task.Segment.Size, err)
+			return err
+		})
+		if err == nil || p.refresher == nil || !isAuthError(err) {
+			break
 		}
+		// Signed URL probably expired: retry once the URLs are renewed
+		refreshed, rerr := p.refresher.expired(p.ctx, gen)
+		if rerr != nil {
+			p.log.Warn("segment URL refresh failed", "error", rerr)
+		}
+		if !refreshed {
+			break
+		}
+	}
+	if err == nil {
+		if p.refresher != nil {
+			p.refresher.succeeded()
+		}
+		p.completed.Add(1)
+		p.totalBytes.Add(task.Segment.Size)
+		p.sendProgress(task, task.Segment.Size, nil)
+
+		// Notify checkpoint of successful download
+		if p.onSegmentDone != nil {
+			p.onSegmentDone(task.Track.ID, task.Segment.Index, task.record)
+		}
+		return
+	}
+
+	if p.ctx.Err() != nil {
+		p.sendProgress(task, 0, p.ctx.Err())
+		return
+	}
+
+	p.failed.Add(1)
+	p.log.Debug("segment failed", "track", task.Track.ID, "segment", task.Segment.Index, "error", err)
+	p.gapsMu.Lock()
+	p.gaps = append(p.gaps, Gap{
+		TrackID:  task.Track.ID,
+		Index:    task.Segment.Index,
+		Start:    task.Segment.Start,
+		Duration: task.Segment.Duration,
+		Err:      err,
+	})
+	p.gapsMu.Unlock()
+
+	if p.onSegmentFailed != nil {
+		p.onSegmentFailed(task.Track.ID, task.Segment.Index)
+	}
 
-		data, err := p.doRequest(task)
+	p.sendProgress(task, 0, fmt.Errorf("segment %d: %w", task.Segment.Index, err))
+}
+
+// download makes a single attempt at fetching a segment.
+func (p *WorkerPool) download(task *SegmentTask) error {
+	if p.tempDir != "" {
+		return p.downloadToDisk(task)
+	}
+	return p.downloadToMemory(task)
+}
+
+// downloadToMemory fetches and decrypts a segment, keeping it in memory.
+func (p *WorkerPool) downloadToMemory(task *SegmentTask) error {
+	resp, err := p.doRequest(task, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := httpclient.CheckResponse(resp); err != nil {
+		return err
+	}
+	if task.Segment.ByteRange != nil && resp.StatusCode == http.StatusOK {
+		return httpclient.Permanent(ErrRangeIgnored)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if p.checkSegments {
+		if err := checkLength(resp, task.Segment.ByteRange, int64(len(data)), int64(len(data))); err != nil {
+			return err
+		}
+	}
+	task.Segment.Size = int64(len(data))
+	task.Segment.Data = data
+	if task.DecFunc != nil {
+		if err := task.DecFunc(task.Track, task.Segment); err != nil {
+			return err
+		}
+	}
+	if p.checkSegments {
+		return sniffSegment(task.Segment.Data[:min(len(task.Segment.Data), sniffLen)], task.Track)
+	}
+	return nil
+}
+
+// downloadToDisk streams a segment into a .part file, then decrypts or
+// renames it into place. An interrupted body is continued by the next
+// attempt, or by the next run if the checkpoint was saved.
+func (p *WorkerPool) downloadToDisk(task *SegmentTask) error {
+	segPath := filepath.Join(p.tempDir, fmt.Sprintf("%s_%05d.seg", task.Track.ID, task.Segment.Index))
+	partPath := segPath + ".part"
+
+	rec, err := p.fetchToFile(task, partPath)
+	if err != nil {
+		return err
+	}
+	size := rec.Size
+
+	if task.DecFunc != nil {
+		data, err := os.ReadFile(partPath)
+		if err != nil {
+			return fmt.Errorf("read segment: %w", err)
+		}
+		task.Segment.Data = data
+		err = task.DecFunc(task.Track, task.Segment)
+		if err == nil && p.checkSegments {
+			// Encrypted bodies can only be checked once decrypted
+			err = sniffSegment(task.Segment.Data[:min(len(task.Segment.Data), sniffLen)], task.Track)
+		}
+		if err != nil {
+			task.Segment.Data = nil
+			p.discardPartial(task, partPath)
+			return err
+		}
+		// Write via a temp file so segPath only ever appears complete
+		tmpPath := segPath + ".tmp"
+		rec = newSegmentRecord(int64(len(task.Segment.Data)), crc32.Checksum(task.Segment.Data, crc32c))
+		err = os.WriteFile(tmpPath, task.Segment.Data, 0644)
+		task.Segment.Data = nil // Release memory
 		if err == nil {
-			task.Segment.Size = int64(len(data))
-
-			// Run decryption if needed (on in-memory data)
-			if task.DecFunc != nil {
-				task.Segment.Data = data
-				if err = task.DecFunc(task.Track, task.Segment); err != nil {
-					lastErr = err
-					continue
-				}
-				data = task.Segment.Data // Use decrypted data
+			err = os.Rename(tmpPath, segPath)
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+			return fmt.Errorf("write segment: %w", err)
+		}
+		os.Remove(partPath)
+	} else {
+		if p.checkSegments {
+			if err := sniffFile(partPath, task.Track); err != nil {
+				p.discardPartial(task, partPath)
+				return err
 			}
+		}
+		if err := os.Rename(partPath, segPath); err != nil {
+			return fmt.Errorf("write segment: %w", err)
+		}
+	}
 
-			// Write to disk if tempDir is set
-			if p.tempDir != "" {
-				segPath := filepath.Join(p.tempDir, fmt.Sprintf("%s_%05d.seg", task.Track.ID, task.Segment.Index))
-				if err = os.WriteFile(segPath, data, 0644); err != nil {
-					lastErr = fmt.Errorf("write segment: %w", err)
-					continue
-				}
-				task.Segment.FilePath = segPath
-				task.Segment.Data = nil // Release memory
-			} else {
-				task.Segment.Data = data
-			}
+	if p.checkpoint != nil {
+		p.checkpoint.ClearPartial(task.Track.ID, task.Segment.Index)
+	}
+	task.Segment.Size = size
+	task.Segment.FilePath = segPath
+	task.record = rec
+	return nil
+}
 
-			p.completed.Add(1)
-			p.totalBytes.Add(task.Segment.Size)
-			p.sendProgress(task, task.Segment.Size, nil)
+// fetchToFile streams a segment body into partPath and returns the size
+// and CRC-32C of the whole file. Bytes already in partPath are kept when
+// If-Range confirms the resource is unchanged; otherwise the server sends
+// the full body again.
+func (p *WorkerPool) fetchToFile(task *SegmentTask, partPath string) (SegmentRecord, error) {
+	state := p.partialState(task, partPath)
 
-			// Notify checkpoint of successful download
-			if p.onSegmentDone != nil {
-				p.onSegmentDone(task.Track.ID, task.Segment.Index)
-			}
-			return
+	resp, err := p.doRequest(task, state.Offset, state.validator())
+	if err != nil {
+		return SegmentRecord{}, err
+	}
+	defer resp.Body.Close()
+
+	// A 206 must start where this request asked it to
+	want := state.Offset
+	if br := task.Segment.ByteRange; br != nil {
+		want += br.Start
+	}
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		if start, ok := contentRangeStart(resp.Header.Get("Content-Range")); !ok || start != want {
+			p.discardPartial(task, partPath)
+			return SegmentRecord{}, fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+		if state.Offset > 0 {
+			flags |= os.O_APPEND
+		} else {
+			flags |= os.O_TRUNC
+		}
+	case resp.StatusCode == http.StatusOK && task.Segment.ByteRange != nil:
+		// The whole resource, not the segment's byte range
+		p.discardPartial(task, partPath)
+		if state.Offset > 0 {
+			// If-Range failed: the resource changed, start the range over
+			return SegmentRecord{}, fmt.Errorf("HTTP 200 resuming at byte %d", state.Offset)
 		}
+		return SegmentRecord{}, httpclient.Permanent(ErrRangeIgnored)
+	case resp.StatusCode == http.StatusOK:
+		// Full body: new download, or the resource changed since the partial
+		state = PartialSegment{}
+		flags |= os.O_TRUNC
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && state.Offset > 0:
+		p.discardPartial(task, partPath)
+		return SegmentRecord{}, fmt.Errorf("HTTP %d resuming at byte %d", resp.StatusCode, state.Offset)
+	default:
+		return SegmentRecord{}, httpclient.CheckResponse(resp)
+	}
+	state.ETag = resp.Header.Get("ETag")
+	state.LastModified = resp.Header.Get("Last-Modified")
+
+	// Hash the body as it is written; a resumed file's earlier bytes first
+	h := crc32.New(crc32c)
+	if flags&os.O_APPEND != 0 {
+		if err := hashPrefix(h, partPath, state.Offset); err != nil {
+			p.discardPartial(task, partPath)
+			return SegmentRecord{}, err
+		}
+	}
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return SegmentRecord{}, fmt.Errorf("write segment: %w", err)
+	}
+	n, err := io.Copy(io.MultiWriter(f, h), resp.Body)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("write segment: %w", cerr)
+	}
 
-		lastErr = err
-		if p.verbose {
-			fmt.Printf("Segment %d attempt %d failed: %v\n", task.Segment.Index, attempt+1, err)
+	state.Offset += n
+	if err == nil && p.checkSegments {
+		if err := checkLength(resp, task.Segment.ByteRange, n, state.Offset); err != nil {
+			p.discardPartial(task, partPath)
+			return SegmentRecord{}, err
 		}
 	}
+	if p.checkpoint != nil {
+		p.checkpoint.SetPartial(task.Track.ID, task.Segment.Index, state)
+	}
+	if err != nil {
+		return SegmentRecord{}, err
+	}
+	return newSegmentRecord(state.Offset, h.Sum32()), nil
+}
 
-	p.failed.Add(1)
-	p.errorsMu.Lock()
-	p.errors = append(p.errors, lastErr)
-	p.errorsMu.Unlock()
+// hashPrefix adds the first n bytes of the file at path to h.
+func hashPrefix(h hash.Hash32, path string, n int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return fmt.Errorf("read segment: %w", err)
+	}
+	return nil
+}
 
-	err := fmt.Errorf("segment %d: %w (after %d attempts)", task.Segment.Index, lastErr, p.maxRetries)
-	p.sendProgress(task, 0, err)
+// partialState returns how to continue a segment's .part file. The file
+// size is authoritative; without a validator to send in If-Range the
+// partial body cannot be trusted and is started over.
+func (p *WorkerPool) partialState(task *SegmentTask, partPath string) PartialSegment {
+	info, err := os.Stat(partPath)
+	if err != nil || info.Size() == 0 || p.checkpoint == nil {
+		return PartialSegment{}
+	}
+	state, ok := p.checkpoint.GetPartial(task.Track.ID, task.Segment.Index)
+	if !ok || state.validator() == "" {
+		return PartialSegment{}
+	}
+	state.Offset = info.Size()
+	return state
 }
 
-// doRequest performs a single HTTP request.
-func (p *WorkerPool) doRequest(task *SegmentTask) ([]byte, error) {
-	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, task.Segment.URL, nil)
+// discardPartial removes a .part file that cannot be continued.
+func (p *WorkerPool) discardPartial(task *SegmentTask, partPath string) {
+	os.Remove(partPath)
+	if p.checkpoint != nil {
+		p.checkpoint.ClearPartial(task.Track.ID, task.Segment.Index)
+	}
+}
+
+// doRequest starts a single HTTP request, skipping the first offset bytes
+// of the segment. ifRange is sent with resumed requests.
+func (p *WorkerPool) doRequest(task *SegmentTask, offset int64, ifRange string) (*http.Response, error) {
+	url := task.Segment.URL
+	if p.refresher != nil {
+		url = p.refresher.segmentURL(task.Segment)
+	}
+	req, err := http.NewRequestWithContext(p.ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("create request: %w", err)
 	}
@@ -182,22 +503,36 @@ func (p *WorkerPool) doRequest(task *SegmentTask) ([]byte, error) {
 		req.Header.Set(k, v)
 	}
 
-	if task.Segment.ByteRange != nil {
-		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d",
-			task.Segment.ByteRange.Start, task.Segment.ByteRange.End))
+	if br := task.Segment.ByteRange; br != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", br.Start+offset, br.End))
+	} else if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	if offset > 0 && ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
 	}
 
+	start := time.Now()
 	resp, err := p.client.Do(req)
-	if err != nil {
-		return nil, err
+	if err == nil && p.adaptive != nil {
+		p.adaptive.observeLatency(time.Since(start))
 	}
-	defer resp.Body.Close()
+	return resp, err
+}
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+// contentRangeStart returns the first byte position of a Content-Range
+// header such as "bytes 100-199/1000".
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
 	}
-
-	return io.ReadAll(resp.Body)
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
 }
 
 // sendProgress sends a progress update.
@@ -209,6 +544,7 @@ func (p *WorkerPool) sendProgress(task *SegmentTask, bytes int64, err error) {
 		BytesLoaded:  bytes,
 		Completed:    err == nil,
 		Error:        err,
+		Concurrency:  p.Concurrency(),
 	}:
 	case <-p.ctx.Done():
 	}
@@ -219,26 +555,17 @@ func (p *WorkerPool) Submit(task *SegmentTask) {
 	p.taskQueue <- task
 }
 
-// Wait blocks until all tasks are complete.
-func (p *WorkerPool) Wait() error {
+// Wait blocks until all tasks are complete and returns the segments that
+// failed, sorted by track and index.
+func (p *WorkerPool) Wait() []Gap {
 	close(p.taskQueue)
 	p.wg.Wait()
+	close(p.done)
 
-	failed := p.failed.Load()
-	completed := p.completed.Load()
-	total := failed + completed
-
-	if failed > 0 && total > 0 {
-		failRate := float64(failed) / float64(total)
-		if failRate > 0.01 { // Allow up to 1% failure rate
-			return fmt.Errorf("%d/%d segments failed (%.1f%%)", failed, total, failRate*100)
-		}
-		if p.verbose {
-			fmt.Printf("Warning: %d segments failed (%.2f%% fail rate)\n", failed, failRate*100)
-		}
-	}
-
-	return nil
+	p.gapsMu.Lock()
+	defer p.gapsMu.Unlock()
+	sortGaps(p.gaps)
+	return p.gaps
 }
 
 // Stop gracefully shuts down the pool.
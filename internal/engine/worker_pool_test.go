@@ -0,0 +1,217 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/httpclient"
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestWorkerPoolResumesPartialSegment(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var requests atomic.Int32
+	var resumedRange atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if requests.Add(1) == 1 {
+			// Drop the connection halfway through the body
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body[:len(body)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		resumedRange.Store(r.Header.Get("Range"))
+		http.ServeContent(w, r, "seg.ts", modTime, bytes.NewReader(body))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	cp := NewCheckpoint(srv.URL, dir, nil)
+	progressCh := make(chan ProgressUpdate, 4)
+
+	pool := NewWorkerPool(1, srv.Client(), progressCh)
+	pool.SetTempDir(dir)
+	pool.SetCheckpoint(cp)
+	var rec SegmentRecord
+	pool.SetOnSegmentDone(func(_ string, _ int, r SegmentRecord) { rec = r })
+	pool.Start(context.Background())
+
+	seg := &models.Segment{Index: 0, URL: srv.URL + "/seg.ts"}
+	pool.Submit(&SegmentTask{Segment: seg, Track: &models.Track{ID: "v"}})
+	if gaps := pool.Wait(); len(gaps) > 0 {
+		t.Fatalf("segment failed: %v", gaps[0].Err)
+	}
+
+	if got := resumedRange.Load(); got != "bytes=50000-" {
+		t.Errorf("retry Range = %v, want bytes=50000-", got)
+	}
+	data, err := os.ReadFile(seg.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, body) {
+		t.Errorf("segment has %d bytes, want %d", len(data), len(body))
+	}
+	if _, ok := cp.GetPartial("v", 0); ok {
+		t.Error("partial state not cleared after completion")
+	}
+	// Hashed while written, including the bytes from the first attempt
+	if want, _ := hashFile(seg.FilePath); rec != want {
+		t.Errorf("record %+v, want %+v", rec, want)
+	}
+}
+
+func TestWorkerPoolResumesByteRangeSegment(t *testing.T) {
+	file := bytes.Repeat([]byte("0123456789"), 10000)
+	br := &models.ByteRange{Start: 1000, End: 60999}
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		switch requests.Add(1) {
+		case 1:
+			// Drop the connection halfway through the range
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", br.Start, br.End, len(file)))
+			w.Header().Set("Content-Length", strconv.FormatInt(br.End-br.Start+1, 10))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(file[br.Start : br.Start+30000])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case 2:
+			// The resume is answered with the whole file
+			w.Write(file)
+		default:
+			http.ServeContent(w, r, "seg.ts", modTime, bytes.NewReader(file))
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	pool := NewWorkerPool(1, srv.Client(), make(chan ProgressUpdate, 4))
+	pool.SetTempDir(dir)
+	pool.SetCheckpoint(NewCheckpoint(srv.URL, dir, nil))
+	pool.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond})
+	pool.Start(context.Background())
+
+	seg := &models.Segment{Index: 0, URL: srv.URL + "/seg.ts", ByteRange: br}
+	pool.Submit(&SegmentTask{Segment: seg, Track: &models.Track{ID: "v"}})
+	if gaps := pool.Wait(); len(gaps) > 0 {
+		t.Fatalf("segment failed: %v", gaps[0].Err)
+	}
+
+	if n := requests.Load(); n != 3 {
+		t.Errorf("%d requests, want 3", n)
+	}
+	data, err := os.ReadFile(seg.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, file[br.Start:br.End+1]) {
+		t.Errorf("segment has %d bytes, want the %d byte range", len(data), br.End-br.Start+1)
+	}
+}
+
+func TestWorkerPoolRefreshesExpiredURLs(t *testing.T) {
+	var token atomic.Value
+	token.Store("old")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("token") != token.Load() {
+			http.Error(w, "expired", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("SEG"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name        string
+		newToken    string
+		wantFetches int32
+		wantDone    int
+	}{
+		{"refreshed", "new", 1, 3},
+		{"still forbidden", "bad", 1, 0}, // no refresh loop without progress
+	}
+	for _, tt := range tests {
+		token.Store("new")
+		track := &models.Track{ID: "v"}
+		for i := range 3 {
+			track.Segments = append(track.Segments, &models.Segment{Index: i, URL: fmt.Sprintf("%s/%d.ts?token=old", srv.URL, i)})
+		}
+
+		var fetches atomic.Int32
+		progressCh := make(chan ProgressUpdate, 8)
+		pool := NewWorkerPool(2, srv.Client(), progressCh)
+		pool.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 1})
+		pool.SetURLRefresh(1, func(ctx context.Context) (map[*models.Segment]string, error) {
+			fetches.Add(1)
+			urls := make(map[*models.Segment]string)
+			for _, seg := range track.Segments {
+				urls[seg] = fmt.Sprintf("%s/%d.ts?token=%s", srv.URL, seg.Index, tt.newToken)
+			}
+			return urls, nil
+		})
+		pool.Start(context.Background())
+		for _, seg := range track.Segments {
+			pool.Submit(&SegmentTask{Segment: seg, Track: track})
+		}
+		gaps := pool.Wait()
+
+		if got := fetches.Load(); got != tt.wantFetches {
+			t.Errorf("%s: %d refreshes, want %d", tt.name, got, tt.wantFetches)
+		}
+		if done := len(track.Segments) - len(gaps); done != tt.wantDone {
+			t.Errorf("%s: %d segments done, want %d", tt.name, done, tt.wantDone)
+		}
+	}
+}
+
+func TestWorkerPoolStopsRefreshingWhenManifestForbidden(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	track := &models.Track{ID: "v"}
+	for i := range 8 {
+		track.Segments = append(track.Segments, &models.Segment{Index: i, URL: fmt.Sprintf("%s/%d.ts", srv.URL, i)})
+	}
+
+	var fetches atomic.Int32
+	pool := NewWorkerPool(2, srv.Client(), make(chan ProgressUpdate, 16))
+	pool.SetRetryPolicy(httpclient.RetryPolicy{MaxAttempts: 1})
+	pool.SetURLRefresh(1, func(ctx context.Context) (map[*models.Segment]string, error) {
+		fetches.Add(1)
+		resp, err := srv.Client().Get(srv.URL + "/manifest.m3u8")
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		return nil, httpclient.CheckResponse(resp)
+	})
+	pool.Start(context.Background())
+	for _, seg := range track.Segments {
+		pool.Submit(&SegmentTask{Segment: seg, Track: track})
+	}
+	gaps := pool.Wait()
+
+	// Each later 403 must not fetch the forbidden manifest again
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("%d manifest fetches, want 1", got)
+	}
+	if len(gaps) != len(track.Segments) {
+		t.Errorf("%d gaps, want %d", len(gaps), len(track.Segments))
+	}
+}
@@ -0,0 +1,172 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Budget is a bandwidth limit shared by several clients, e.g. the tasks of
+// a download manager. Each client reads through a BudgetShare and gets
+// the part of the limit matching its weight among the shares currently
+// held. The limit can be changed at any time, and can follow a daily
+// schedule.
+type Budget struct {
+	mu       sync.Mutex
+	limit    int64             // Bytes per second outside the schedule, 0 = unlimited
+	schedule []BandwidthWindow // Limits by time of day
+	timer    *time.Timer       // Applies the next scheduled change
+	now      func() time.Time  // time.Now, fixed in tests
+	shares   map[*BudgetShare]struct{}
+}
+
+// scheduleCheck is the longest the schedule goes unchecked, so clock
+// changes and suspends are picked up.
+const scheduleCheck = time.Minute
+
+// NewBudget returns a budget of bytesPerSec, 0 = unlimited.
+func NewBudget(bytesPerSec int64) *Budget {
+	return &Budget{limit: max(bytesPerSec, 0), now: time.Now, shares: make(map[*BudgetShare]struct{})}
+}
+
+// Limit returns the total bytes per second outside scheduled windows,
+// 0 = unlimited.
+func (b *Budget) Limit() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit
+}
+
+// SetLimit changes the total bytes per second outside scheduled windows,
+// 0 = unlimited. Shares are adjusted immediately, including for reads
+// already in progress.
+func (b *Budget) SetLimit(bytesPerSec int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limit = max(bytesPerSec, 0)
+	b.rebalance()
+}
+
+// Current returns the total bytes per second in effect now, 0 = unlimited.
+func (b *Budget) Current() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return scheduledLimit(b.schedule, b.limit, b.now())
+}
+
+// SetSchedule makes the budget follow windows: inside a window its limit
+// applies, outside them the SetLimit value. nil removes the schedule.
+func (b *Budget) SetSchedule(windows []BandwidthWindow) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.schedule = windows
+	b.rebalance()
+	b.armTimer()
+}
+
+// Stop ends following the schedule. The current limit stays in effect.
+func (b *Budget) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
+
+// armTimer schedules the next limit change. b.mu is held.
+func (b *Budget) armTimer() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.schedule) == 0 {
+		return
+	}
+	now := b.now()
+	wait := min(nextBoundary(b.schedule, now).Sub(now), scheduleCheck)
+	b.timer = time.AfterFunc(wait, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.timer == nil {
+			return // Stopped
+		}
+		b.rebalance()
+		b.armTimer()
+	})
+}
+
+// Share adds a client with the given weight (<= 0 means 1) to the budget.
+// Release it when the client is done, so its part goes to the others.
+func (b *Budget) Share(weight float64) *BudgetShare {
+	s := &BudgetShare{budget: b, limiter: rate.NewLimiter(rate.Inf, 64*1024)}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s.weight = normWeight(weight)
+	b.shares[s] = struct{}{}
+	b.rebalance()
+	return s
+}
+
+// rebalance splits the current limit between the shares by weight. b.mu
+// is held.
+func (b *Budget) rebalance() {
+	limit := scheduledLimit(b.schedule, b.limit, b.now())
+	var total float64
+	for s := range b.shares {
+		total += s.weight
+	}
+	for s := range b.shares {
+		if limit == 0 {
+			s.limiter.SetLimit(rate.Inf)
+		} else {
+			s.limiter.SetLimit(rate.Limit(float64(limit) * s.weight / total))
+		}
+	}
+}
+
+func normWeight(w float64) float64 {
+	if w <= 0 {
+		return 1
+	}
+	return w
+}
+
+// BudgetShare is one client's part of a Budget.
+type BudgetShare struct {
+	budget  *Budget
+	weight  float64 // Guarded by budget.mu
+	limiter *rate.Limiter
+}
+
+// Middleware limits response bodies to the share's rate.
+func (s *BudgetShare) Middleware() Middleware {
+	return RateLimit(s.limiter)
+}
+
+// Rate returns the share's current bytes per second, 0 = unlimited.
+func (s *BudgetShare) Rate() int64 {
+	if l := s.limiter.Limit(); l != rate.Inf {
+		return int64(l)
+	}
+	return 0
+}
+
+// SetWeight changes the share's weight (<= 0 means 1).
+func (s *BudgetShare) SetWeight(weight float64) {
+	s.budget.mu.Lock()
+	defer s.budget.mu.Unlock()
+	s.weight = normWeight(weight)
+	s.budget.rebalance()
+}
+
+// Release removes the share from the budget. Reads through it are no
+// longer limited.
+func (s *BudgetShare) Release() {
+	s.budget.mu.Lock()
+	defer s.budget.mu.Unlock()
+	delete(s.budget.shares, s)
+	s.limiter.SetLimit(rate.Inf)
+	s.budget.rebalance()
+}
@@ -0,0 +1,36 @@
+package httpclient
+
+import "testing"
+
+func TestBudgetSplitsByWeight(t *testing.T) {
+	b := NewBudget(900)
+	a := b.Share(1)
+	if got := a.Rate(); got != 900 {
+		t.Errorf("single share rate = %d, want 900", got)
+	}
+
+	c := b.Share(2)
+	if a.Rate() != 300 || c.Rate() != 600 {
+		t.Errorf("rates = %d, %d; want 300, 600", a.Rate(), c.Rate())
+	}
+
+	c.SetWeight(0) // Same as 1
+	if a.Rate() != 450 || c.Rate() != 450 {
+		t.Errorf("equal weights: rates = %d, %d; want 450 each", a.Rate(), c.Rate())
+	}
+
+	b.SetLimit(300)
+	if a.Rate() != 150 {
+		t.Errorf("after SetLimit(300): rate = %d, want 150", a.Rate())
+	}
+
+	c.Release()
+	if a.Rate() != 300 || c.Rate() != 0 {
+		t.Errorf("after release: rates = %d, %d; want 300, unlimited", a.Rate(), c.Rate())
+	}
+
+	b.SetLimit(0)
+	if a.Rate() != 0 {
+		t.Errorf("unlimited budget: rate = %d", a.Rate())
+	}
+}
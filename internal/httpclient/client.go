@@ -2,11 +2,12 @@
 package httpclient
 
 import (
-	"context"
 	"crypto/tls"
-	"io"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -17,7 +18,42 @@ type Config struct {
 	Timeout         time.Duration
 	MaxConnsPerHost int
 	DisableHTTP2    bool
-	Headers         map[string]string
+
+	// Proxy is a proxy URL (http, https, socks5 or socks5h, optionally with
+	// user:password), "direct" for no proxy, or "" to use HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY from the environment.
+	Proxy string
+
+	// TLS is used for all connections (see NewTLSConfig); nil = system
+	// roots, TLS 1.2 or later.
+	TLS *tls.Config
+
+	// Resolve maps "host:port" to the IP address to connect to instead.
+	Resolve map[string]string
+
+	// Overrides maps "host:port" to the SNI and Host header requests to it
+	// send instead of host (see HostPort). The SNI override doesn't apply
+	// through a proxy.
+	Overrides map[string]HostOverride
+
+	// Base is a client to wrap instead of building a transport. Its
+	// transport and timeout are used as they are; MaxConnsPerHost,
+	// DisableHTTP2, Proxy, TLS, Resolve and the SNI overrides are ignored.
+	Base *http.Client
+
+	// Middleware settings, applied to every request
+	Headers      map[string]string // set unless the request sets them
+	Cookies      string            // "name=value; name2=value2", added to the request's cookies
+	Jar          http.CookieJar    // receives Set-Cookie, overrides Base's jar if set
+	UserAgent    string            // "" = Go's default
+	MaxBandwidth int64             // bytes per second for response bodies, 0 = unlimited
+	StallTimeout time.Duration     // fail requests that get no data for this long, 0 = never
+	Logger       *slog.Logger      // requests are logged at debug level
+
+	// Middleware runs after the built-in middleware, closest to the
+	// transport. Clients given the same instance share its state, e.g. a
+	// HostLimits budget.
+	Middleware []Middleware
 }
 
 // DefaultConfig returns sensible defaults for media downloads.
@@ -29,19 +65,80 @@ func DefaultConfig() Config {
 	}
 }
 
-// New creates an optimized HTTP client for high-throughput downloads.
+// New creates an optimized HTTP client for high-throughput downloads, with
+// the middleware the config asks for. An invalid proxy is treated as a
+// connection error on every request; use ParseProxy to validate it first.
 func New(cfg Config) *http.Client {
+	var client *http.Client
+	if cfg.Base != nil {
+		c := *cfg.Base
+		client = &c
+	} else {
+		client = &http.Client{
+			Transport: newTransport(cfg),
+			Timeout:   cfg.Timeout,
+		}
+	}
+	if cfg.Jar != nil {
+		client.Jar = cfg.Jar
+	}
+	client.Transport = Chain(client.Transport, cfg.middleware()...)
+	return client
+}
+
+// middleware returns the middleware for cfg, outermost first.
+func (cfg Config) middleware() []Middleware {
+	var mws []Middleware
+	if cfg.Logger != nil {
+		mws = append(mws, Logging(cfg.Logger))
+	}
+	if len(cfg.Headers) > 0 {
+		mws = append(mws, Headers(cfg.Headers))
+	}
+	if cfg.Cookies != "" {
+		mws = append(mws, Cookies(cfg.Cookies))
+	}
+	if cfg.UserAgent != "" {
+		mws = append(mws, UserAgent(cfg.UserAgent))
+	}
+	if len(cfg.Overrides) > 0 {
+		mws = append(mws, hostHeaders(cfg.Overrides))
+	}
+	if cfg.MaxBandwidth > 0 {
+		// Allow bursts of 64KB
+		mws = append(mws, RateLimit(rate.NewLimiter(rate.Limit(cfg.MaxBandwidth), 64*1024)))
+	}
+	mws = append(mws, cfg.Middleware...)
+	if cfg.StallTimeout > 0 {
+		// Innermost, so waiting for a host or bandwidth budget doesn't count
+		mws = append(mws, StallTimeout(cfg.StallTimeout))
+	}
+	return mws
+}
+
+// newTransport returns a transport tuned for many parallel downloads.
+func newTransport(cfg Config) *http.Transport {
 	if cfg.MaxConnsPerHost == 0 {
 		cfg.MaxConnsPerHost = 100
 	}
 
+	proxy, err := ParseProxy(cfg.Proxy)
+	if err != nil {
+		proxy = func(*http.Request) (*url.URL, error) { return nil, err }
+	}
+
+	tlsConfig := cfg.TLS
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 		DualStack: true,
 	}
 
-	transport := &http.Transport{
+	t := &http.Transport{
 		MaxIdleConns:        200,
 		MaxIdleConnsPerHost: cfg.MaxConnsPerHost,
 		MaxConnsPerHost:     cfg.MaxConnsPerHost,
@@ -51,74 +148,36 @@ func New(cfg Config) *http.Client {
 		ResponseHeaderTimeout: 30 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 
+		Proxy:              proxy,
 		DisableCompression: true, // Segments are already compressed
 		ForceAttemptHTTP2:  !cfg.DisableHTTP2,
-		DialContext:        dialer.DialContext,
-
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
-	}
-
-	return &http.Client{
-		Transport: transport,
-		Timeout:   cfg.Timeout,
+		DialContext:        resolvingDialer(dialer.DialContext, cfg.Resolve),
+		TLSClientConfig:    tlsConfig.Clone(),
 	}
+	t.DialTLSContext = overridingTLSDialer(t, cfg.Overrides)
+	return t
 }
 
-// NewWithRateLimit creates a client with bandwidth limiting.
-// bytesPerSec is the maximum download speed in bytes per second.
-// Set to 0 for unlimited.
-func NewWithRateLimit(cfg Config, bytesPerSec int64) *http.Client {
-	client := New(cfg)
-
-	if bytesPerSec > 0 {
-		// Create rate limiter: allow bursts of 64KB
-		limiter := rate.NewLimiter(rate.Limit(bytesPerSec), 64*1024)
-		client.Transport = &rateLimitedTransport{
-			base:    client.Transport,
-			limiter: limiter,
-		}
+// ParseProxy returns the proxy function for a Config.Proxy value.
+func ParseProxy(proxy string) (func(*http.Request) (*url.URL, error), error) {
+	switch proxy {
+	case "":
+		return http.ProxyFromEnvironment, nil
+	case "direct":
+		return nil, nil
 	}
 
-	return client
-}
-
-// rateLimitedTransport wraps a transport with rate limiting.
-type rateLimitedTransport struct {
-	base    http.RoundTripper
-	limiter *rate.Limiter
-}
-
-func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	resp, err := t.base.RoundTrip(req)
+	u, err := url.Parse(proxy)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
 	}
-
-	resp.Body = &rateLimitedReader{
-		r:       resp.Body,
-		limiter: t.limiter,
-		ctx:     req.Context(),
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (want http, https, socks5 or socks5h)", u.Scheme)
 	}
-	return resp, nil
-}
-
-// rateLimitedReader wraps an io.ReadCloser with rate limiting.
-type rateLimitedReader struct {
-	r       io.ReadCloser
-	limiter *rate.Limiter
-	ctx     context.Context
-}
-
-func (r *rateLimitedReader) Read(p []byte) (int, error) {
-	// Wait for rate limiter before reading
-	if err := r.limiter.WaitN(r.ctx, len(p)); err != nil {
-		return 0, err
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy URL %q has no host", proxy)
 	}
-	return r.r.Read(p)
-}
-
-func (r *rateLimitedReader) Close() error {
-	return r.r.Close()
+	return http.ProxyURL(u), nil
 }
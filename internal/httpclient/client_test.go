@@ -0,0 +1,49 @@
+package httpclient
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseProxy(t *testing.T) {
+	for _, proxy := range []string{"", "direct", "http://proxy:8080", "https://u:p@proxy", "socks5://127.0.0.1:1080", "socks5h://proxy:1080"} {
+		if _, err := ParseProxy(proxy); err != nil {
+			t.Errorf("ParseProxy(%q): %v", proxy, err)
+		}
+	}
+	for _, proxy := range []string{"ftp://proxy", "socks4://proxy:1080", "http://", "://bad", "none"} {
+		if _, err := ParseProxy(proxy); err == nil {
+			t.Errorf("ParseProxy(%q) accepted", proxy)
+		}
+	}
+}
+
+func TestClientUsesAuthenticatedProxy(t *testing.T) {
+	var gotURL, gotAuth string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotURL = r.URL.String()
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	cfg := DefaultConfig()
+	cfg.Proxy = strings.Replace(proxy.URL, "http://", "http://user:secret@", 1)
+	resp, err := New(cfg).Get("http://media.example/seg.ts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "via proxy" || gotURL != "http://media.example/seg.ts" {
+		t.Errorf("proxy saw %q and returned %q", gotURL, body)
+	}
+	if want := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret")); gotAuth != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", gotAuth, want)
+	}
+}
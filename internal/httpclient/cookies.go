@@ -0,0 +1,291 @@
+package httpclient
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies in files exported by browsers and curl.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar is an http.CookieJar that can be loaded from and saved to a
+// Netscape cookies.txt file, as exported by browser extensions, curl and
+// yt-dlp.
+type CookieJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	entries map[string]cookieEntry // By domain, path and name
+	changed bool                   // Set-Cookie received since Load or Save
+}
+
+// cookieEntry is one line of a cookies.txt file.
+type cookieEntry struct {
+	Domain     string // Host, or ".domain" when subdomains match too
+	Subdomains bool
+	Path       string
+	Secure     bool
+	HttpOnly   bool
+	Expires    time.Time // Zero for session cookies
+	Name       string
+	Value      string
+}
+
+func (c cookieEntry) key() string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+// NewCookieJar returns an empty cookie jar.
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List}) // Never fails
+	return &CookieJar{jar: jar, entries: make(map[string]cookieEntry)}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar, remembering the cookies so Save
+// can write them back. Cookies the jar rejects, such as ones for another
+// domain or a public suffix, are not remembered either.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		domain, subdomains, ok := cookieDomain(u.Hostname(), c.Domain)
+		if !ok {
+			continue
+		}
+		e := cookieEntry{
+			Domain:     domain,
+			Subdomains: subdomains,
+			Path:       c.Path,
+			Secure:     c.Secure,
+			HttpOnly:   c.HttpOnly,
+			Name:       c.Name,
+			Value:      c.Value,
+		}
+		if e.Path == "" || e.Path[0] != '/' {
+			e.Path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge < 0:
+			e.Expires = time.Unix(1, 0)
+		case c.MaxAge > 0:
+			e.Expires = time.Now().Add(time.Duration(c.MaxAge) * time.Second)
+		case !c.Expires.IsZero():
+			e.Expires = c.Expires
+		}
+
+		if !e.Expires.IsZero() && !e.Expires.After(time.Now()) {
+			delete(j.entries, e.key())
+		} else {
+			j.entries[e.key()] = e
+		}
+		j.changed = true
+	}
+}
+
+// cookieDomain returns the cookies.txt domain of a cookie set by host with
+// the given Domain attribute, following the checks net/http/cookiejar
+// makes. ok is false if the jar rejects the cookie.
+func cookieDomain(host, attr string) (domain string, subdomains, ok bool) {
+	host = strings.ToLower(host)
+	attr = strings.ToLower(strings.TrimPrefix(attr, "."))
+	if attr == "" || attr == host {
+		// A Domain equal to the host, an IP address or a public suffix
+		// still makes a host-only cookie
+		if attr == "" || net.ParseIP(host) != nil || publicsuffix.List.PublicSuffix(attr) == attr {
+			return host, false, true
+		}
+		return "." + attr, true, true
+	}
+	if net.ParseIP(host) != nil || strings.HasSuffix(attr, ".") ||
+		!strings.HasSuffix(host, "."+attr) || publicsuffix.List.PublicSuffix(attr) == attr {
+		return "", false, false
+	}
+	return "." + attr, true, true
+}
+
+// defaultCookiePath is the path a cookie without a Path attribute gets
+// (RFC 6265 section 5.1.4).
+func defaultCookiePath(urlPath string) string {
+	i := strings.LastIndex(urlPath, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return urlPath[:i]
+}
+
+// Changed reports whether cookies were set since the jar was loaded or
+// saved.
+func (j *CookieJar) Changed() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.changed
+}
+
+// Load adds the cookies in a Netscape cookies.txt file to the jar.
+// Expired cookies are skipped.
+func (j *CookieJar) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		e, ok, err := parseCookieLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, lineNum, err)
+		}
+		if !ok || (!e.Expires.IsZero() && !e.Expires.After(time.Now())) {
+			continue
+		}
+		j.SetCookies(e.url(), []*http.Cookie{e.cookie()})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.changed = false
+	j.mu.Unlock()
+	return nil
+}
+
+// parseCookieLine parses a cookies.txt line. ok is false for comments and
+// blank lines.
+func parseCookieLine(line string) (e cookieEntry, ok bool, err error) {
+	line = strings.TrimRight(line, "\r")
+	if strings.HasPrefix(line, httpOnlyPrefix) {
+		e.HttpOnly = true
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+	} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+		return e, false, nil
+	}
+
+	fields := strings.Split(line, "\t")
+	if len(fields) != 7 {
+		return e, false, fmt.Errorf("want 7 tab-separated fields, got %d", len(fields))
+	}
+	e.Domain = fields[0]
+	e.Subdomains = strings.EqualFold(fields[1], "TRUE")
+	e.Path = fields[2]
+	e.Secure = strings.EqualFold(fields[3], "TRUE")
+	expires, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return e, false, fmt.Errorf("invalid expiry %q", fields[4])
+	}
+	if expires > 0 {
+		e.Expires = time.Unix(expires, 0)
+	}
+	e.Name, e.Value = fields[5], fields[6]
+
+	if e.Domain == "" || e.Name == "" {
+		return e, false, fmt.Errorf("missing domain or name")
+	}
+	if e.Path == "" {
+		e.Path = "/"
+	}
+	return e, true, nil
+}
+
+// url returns a URL the cookie can be set from.
+func (e cookieEntry) url() *url.URL {
+	scheme := "http"
+	if e.Secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: strings.TrimPrefix(e.Domain, "."), Path: e.Path}
+}
+
+func (e cookieEntry) cookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     e.Name,
+		Value:    e.Value,
+		Path:     e.Path,
+		Secure:   e.Secure,
+		HttpOnly: e.HttpOnly,
+		Expires:  e.Expires,
+	}
+	if e.Subdomains {
+		c.Domain = strings.TrimPrefix(e.Domain, ".")
+	}
+	return c
+}
+
+// Save writes the jar's cookies to a Netscape cookies.txt file, replacing
+// it atomically. The file is only readable by the owner.
+func (j *CookieJar) Save(path string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]cookieEntry, 0, len(j.entries))
+	now := time.Now()
+	for _, e := range j.entries {
+		if e.Expires.IsZero() || e.Expires.After(now) {
+			entries = append(entries, e)
+		}
+	}
+	slices.SortFunc(entries, func(a, b cookieEntry) int {
+		return strings.Compare(a.key(), b.key())
+	})
+
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n# Written by veld\n\n")
+	for _, e := range entries {
+		if e.HttpOnly {
+			b.WriteString(httpOnlyPrefix)
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			e.Domain, netscapeBool(e.Subdomains), e.Path, netscapeBool(e.Secure), expires, e.Name, e.Value)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cookies-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(b.String()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	j.changed = false
+	return nil
+}
+
+func netscapeBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
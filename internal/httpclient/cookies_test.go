@@ -0,0 +1,121 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testCookies = `# Netscape HTTP Cookie File
+# comment
+
+127.0.0.1	FALSE	/	FALSE	0	session	old
+#HttpOnly_127.0.0.1	FALSE	/media	FALSE	4102444800	token	abc
+127.0.0.1	FALSE	/	FALSE	1	stale	expired
+.example.com	TRUE	/	TRUE	4102444800	cdn	xyz
+`
+
+func TestCookieJarLoadSendAndSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cookies.txt")
+	if err := os.WriteFile(path, []byte(testCookies), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jar := NewCookieJar()
+	if err := jar.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if jar.Changed() {
+		t.Error("jar changed by Load")
+	}
+
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("Cookie"))
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "renewed", Path: "/", MaxAge: 3600})
+		http.SetCookie(w, &http.Cookie{Name: "tracker", Value: "x", Path: "/", Domain: "evil.example", MaxAge: 3600})
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.Jar = jar
+	cfg.Cookies = "extra=1; session=ignored"
+	client := New(cfg)
+	for _, path := range []string{"/media/seg.ts", "/media/seg.ts", "/manifest.m3u8"} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	want := []string{
+		"token=abc; session=old; extra=1",
+		"token=abc; session=renewed; extra=1",
+		"session=renewed; extra=1",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("cookies sent:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if !jar.Changed() {
+		t.Fatal("Set-Cookie did not mark the jar changed")
+	}
+
+	if err := jar.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	saved := string(data)
+	for _, line := range []string{
+		".example.com\tTRUE\t/\tTRUE\t4102444800\tcdn\txyz",
+		"#HttpOnly_127.0.0.1\tFALSE\t/media\tFALSE\t4102444800\ttoken\tabc",
+		"\tsession\trenewed",
+	} {
+		if !strings.Contains(saved, line) {
+			t.Errorf("saved file lacks %q:\n%s", line, saved)
+		}
+	}
+	if strings.Contains(saved, "stale") || strings.Contains(saved, "session\told") || strings.Contains(saved, "tracker") {
+		t.Errorf("saved file has expired, replaced or foreign cookies:\n%s", saved)
+	}
+
+	reloaded := NewCookieJar()
+	if err := reloaded.Load(path); err != nil {
+		t.Fatalf("reload saved file: %v", err)
+	}
+}
+
+func TestCookieDomain(t *testing.T) {
+	for _, tt := range []struct {
+		host, attr string
+		want       string
+		ok         bool
+	}{
+		{"cdn.example.com", "", "cdn.example.com", true},
+		{"cdn.example.com", ".Example.com", ".example.com", true},
+		{"cdn.example.com", "cdn.example.com", ".cdn.example.com", true},
+		{"cdn.example.com", "other.com", "", false},
+		{"cdn.example.co.uk", "co.uk", "", false},
+		{"co.uk", "co.uk", "co.uk", true},
+		{"127.0.0.1", "0.0.1", "", false},
+	} {
+		got, _, ok := cookieDomain(tt.host, tt.attr)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("cookieDomain(%q, %q) = %q, %v; want %q, %v", tt.host, tt.attr, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseCookieLineErrors(t *testing.T) {
+	for _, line := range []string{
+		"example.com\tFALSE\t/\tFALSE\t0\tname",
+		"example.com\tFALSE\t/\tFALSE\tsoon\tname\tvalue",
+		"\tFALSE\t/\tFALSE\t0\tname\tvalue",
+	} {
+		if _, _, err := parseCookieLine(line); err == nil {
+			t.Errorf("parseCookieLine(%q) accepted", line)
+		}
+	}
+}
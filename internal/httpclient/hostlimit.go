@@ -0,0 +1,260 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// HostPolicy limits the traffic to each host matching Pattern. Every
+// matching host gets its own budget.
+type HostPolicy struct {
+	Pattern        string  // "example.com", "*.example.com" (subdomains) or "*"
+	MaxConns       int     // Concurrent requests (connections over HTTP/1.1), 0 = unlimited
+	RequestsPerSec float64 // 0 = unlimited
+	BytesPerSec    int64   // Response body rate, 0 = unlimited
+}
+
+// Matches reports whether host (without port) matches the pattern. Glob
+// wildcards are allowed; "*.example.com" matches subdomains at any depth
+// and example.com itself.
+func (p HostPolicy) Matches(host string) bool {
+	host = strings.ToLower(host)
+	pattern := strings.ToLower(p.Pattern)
+	if ok, _ := path.Match(pattern, host); ok {
+		return true
+	}
+	return strings.HasPrefix(pattern, "*.") && host == pattern[2:]
+}
+
+// ParseHostPolicy parses "pattern:key=value,...", where the keys are
+// conns, rps and bw (bytes per second, e.g. 500K or 5M), as in
+// "*.cdn.example:conns=64" or "origin.example:conns=4,rps=10".
+func ParseHostPolicy(s string) (HostPolicy, error) {
+	i := strings.LastIndex(s, ":")
+	if i <= 0 || i == len(s)-1 {
+		return HostPolicy{}, fmt.Errorf("invalid host limit %q (want pattern:conns=N,rps=N,bw=RATE)", s)
+	}
+	p := HostPolicy{Pattern: s[:i]}
+	if _, err := path.Match(p.Pattern, ""); err != nil {
+		return HostPolicy{}, fmt.Errorf("invalid host pattern %q: %w", p.Pattern, err)
+	}
+
+	for _, kv := range strings.Split(s[i+1:], ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(kv), "=")
+		var err error
+		switch key {
+		case "conns":
+			p.MaxConns, err = strconv.Atoi(value)
+			if err == nil && p.MaxConns < 0 {
+				err = fmt.Errorf("negative")
+			}
+		case "rps":
+			p.RequestsPerSec, err = strconv.ParseFloat(value, 64)
+			if err == nil && p.RequestsPerSec < 0 {
+				err = fmt.Errorf("negative")
+			}
+		case "bw":
+			p.BytesPerSec, err = ParseRate(value)
+		default:
+			return HostPolicy{}, fmt.Errorf("host limit %q: unknown key %q (want conns, rps or bw)", s, key)
+		}
+		if err != nil {
+			return HostPolicy{}, fmt.Errorf("host limit %q: invalid %s %q", s, key, value)
+		}
+	}
+	return p, nil
+}
+
+// ParseRate parses a byte rate like "500000", "500K", "5M" or "1G"
+// (binary multiples) into bytes per second.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "/s")
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch s[n-1] {
+		case 'k', 'K':
+			mult = 1 << 10
+		case 'm', 'M':
+			mult = 1 << 20
+		case 'g', 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// maxHostStates bounds how many hosts a HostLimiter keeps limiters for.
+// Beyond it, hosts without requests in flight are forgotten.
+const maxHostStates = 1024
+
+// HostLimiter holds the per-host limiters of host policies. Clients that
+// share one, e.g. all downloads of a Manager, also share each host's
+// budget under the same policy.
+type HostLimiter struct {
+	mu    sync.Mutex
+	hosts map[hostKey]*hostState
+}
+
+// NewHostLimiter returns a HostLimiter without any hosts.
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{hosts: make(map[hostKey]*hostState)}
+}
+
+// HostLimits enforces policies in the transport with limiters of its own,
+// see HostLimiter.Middleware.
+func HostLimits(policies []HostPolicy) Middleware {
+	return NewHostLimiter().Middleware(policies)
+}
+
+// Middleware enforces policies in the transport. The first policy whose
+// pattern matches a request's host applies; hosts without a match are not
+// limited.
+func (l *HostLimiter) Middleware(policies []HostPolicy) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return l.roundTrip(next, policies, req)
+		})
+	}
+}
+
+// hostKey identifies the limiters of a host under one policy.
+type hostKey struct {
+	host   string
+	policy HostPolicy
+}
+
+// hostState holds the limiters of one host.
+type hostState struct {
+	conns    chan struct{} // nil = unlimited
+	requests *rate.Limiter // nil = unlimited
+	bytes    *rate.Limiter // nil = unlimited
+	active   int           // Requests in flight, guarded by HostLimiter.mu
+}
+
+// acquire returns the limiters for host, or nil if no policy applies.
+// Each non-nil result must be given back with done.
+func (l *HostLimiter) acquire(policies []HostPolicy, host string) *hostState {
+	i := slices.IndexFunc(policies, func(p HostPolicy) bool { return p.Matches(host) })
+	if i < 0 {
+		return nil
+	}
+	key := hostKey{strings.ToLower(host), policies[i]}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.hosts[key]
+	if !ok {
+		if len(l.hosts) >= maxHostStates {
+			l.evictIdle()
+		}
+		s = newHostState(policies[i])
+		l.hosts[key] = s
+	}
+	s.active++
+	return s
+}
+
+// done marks a request to s as finished.
+func (l *HostLimiter) done(s *hostState) {
+	l.mu.Lock()
+	s.active--
+	l.mu.Unlock()
+}
+
+// evictIdle forgets hosts without requests in flight. Their next request
+// starts with fresh limiters. Called with l.mu held.
+func (l *HostLimiter) evictIdle() {
+	for key, s := range l.hosts {
+		if s.active == 0 {
+			delete(l.hosts, key)
+		}
+	}
+}
+
+func newHostState(p HostPolicy) *hostState {
+	s := &hostState{}
+	if p.MaxConns > 0 {
+		s.conns = make(chan struct{}, p.MaxConns)
+	}
+	if p.RequestsPerSec > 0 {
+		s.requests = rate.NewLimiter(rate.Limit(p.RequestsPerSec), 1)
+	}
+	if p.BytesPerSec > 0 {
+		s.bytes = rate.NewLimiter(rate.Limit(p.BytesPerSec), 64*1024)
+	}
+	return s
+}
+
+func (l *HostLimiter) roundTrip(next http.RoundTripper, policies []HostPolicy, req *http.Request) (*http.Response, error) {
+	s := l.acquire(policies, req.URL.Hostname())
+	if s == nil {
+		return next.RoundTrip(req)
+	}
+	ctx := req.Context()
+
+	var once sync.Once
+	held := false
+	release := func() {
+		once.Do(func() {
+			if held {
+				<-s.conns
+			}
+			l.done(s)
+		})
+	}
+	if s.conns != nil {
+		select {
+		case s.conns <- struct{}{}:
+			held = true
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	if s.requests != nil {
+		if err := s.requests.Wait(ctx); err != nil {
+			release()
+			return nil, err
+		}
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	var body io.ReadCloser = resp.Body
+	if s.bytes != nil {
+		body = &rateLimitedReader{r: body, limiter: s.bytes, ctx: ctx}
+	}
+	// The connection slot is held until the body is closed
+	resp.Body = &releasingBody{ReadCloser: body, release: release}
+	return resp, nil
+}
+
+// releasingBody calls release once the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
@@ -0,0 +1,165 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHostPolicy(t *testing.T) {
+	p, err := ParseHostPolicy("*.cdn.example:conns=64,rps=2.5,bw=5M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := HostPolicy{Pattern: "*.cdn.example", MaxConns: 64, RequestsPerSec: 2.5, BytesPerSec: 5 << 20}
+	if p != want {
+		t.Errorf("got %+v, want %+v", p, want)
+	}
+	for host, match := range map[string]bool{
+		"edge1.cdn.example": true,
+		"cdn.example":       true,
+		"EDGE.CDN.EXAMPLE":  true,
+		"cdn.example.org":   false,
+		"a.b.cdn.example":   true,
+	} {
+		if p.Matches(host) != match {
+			t.Errorf("Matches(%q) = %v, want %v", host, !match, match)
+		}
+	}
+
+	for _, s := range []string{"origin", "origin:", ":conns=1", "origin:conns=-1", "origin:rps=x", "origin:speed=1", "[:conns=1"} {
+		if _, err := ParseHostPolicy(s); err == nil {
+			t.Errorf("ParseHostPolicy(%q) accepted", s)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]int64{"1000": 1000, "500K": 500 << 10, "1.5m": 3 << 19, "2G/s": 2 << 30} {
+		if got, err := ParseRate(s); err != nil || got != want {
+			t.Errorf("ParseRate(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "fast", "-1K"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q) accepted", s)
+		}
+	}
+}
+
+func TestHostLimitsConnsAndRate(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inFlight.Add(-1)
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	get := func(client *http.Client, n int) time.Duration {
+		start := time.Now()
+		var wg sync.WaitGroup
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(srv.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+		return time.Since(start)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Middleware = []Middleware{HostLimits([]HostPolicy{
+		{Pattern: "other.example", MaxConns: 1},
+		{Pattern: "127.0.0.1", MaxConns: 2},
+	})}
+	get(New(cfg), 8)
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrent requests = %d, want 2", got)
+	}
+
+	cfg.Middleware = []Middleware{HostLimits([]HostPolicy{{Pattern: "*", RequestsPerSec: 50}})}
+	if elapsed := get(New(cfg), 6); elapsed < 90*time.Millisecond {
+		t.Errorf("6 requests at 50/s took %s", elapsed)
+	}
+}
+
+func TestHostLimiterSharedByClients(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		inFlight.Add(-1)
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	// Two downloads, each configured with the same limit
+	limiter := NewHostLimiter()
+	policies := []HostPolicy{{Pattern: "127.0.0.1", MaxConns: 2}}
+	var wg sync.WaitGroup
+	for range 2 {
+		cfg := DefaultConfig()
+		cfg.Middleware = []Middleware{limiter.Middleware(policies)}
+		client := New(cfg)
+		for range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := client.Get(srv.URL)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+	}
+	wg.Wait()
+	if got := peak.Load(); got != 2 {
+		t.Errorf("peak concurrent requests = %d, want 2", got)
+	}
+}
+
+func TestHostLimiterEvictsIdleHosts(t *testing.T) {
+	l := NewHostLimiter()
+	policies := []HostPolicy{{Pattern: "*", MaxConns: 1}}
+
+	busy := l.acquire(policies, "busy.example")
+	for i := range 2 * maxHostStates {
+		l.done(l.acquire(policies, fmt.Sprintf("host%d.example", i)))
+	}
+	if n := len(l.hosts); n > maxHostStates {
+		t.Errorf("%d hosts kept, want at most %d", n, maxHostStates)
+	}
+	if l.acquire(policies, "busy.example") != busy {
+		t.Error("host with a request in flight was evicted")
+	}
+}
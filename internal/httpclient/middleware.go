@@ -0,0 +1,248 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Middleware wraps a RoundTripper to add behavior to every request.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Chain wraps base with middlewares. The first middleware sees the request
+// first.
+func Chain(base http.RoundTripper, middlewares ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		base = middlewares[i](base)
+	}
+	return base
+}
+
+// Headers sets headers on every request that doesn't set them itself.
+// Like net/http does for headers set on a request, credentials such as
+// Authorization are left off after a redirect to another domain.
+func Headers(headers map[string]string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			var cloned bool
+			crossDomain := redirectedAway(req)
+			for k, v := range headers {
+				if req.Header.Get(k) != "" || (crossDomain && sensitiveHeaders[http.CanonicalHeaderKey(k)]) {
+					continue
+				}
+				if !cloned {
+					req, cloned = req.Clone(req.Context()), true
+				}
+				req.Header.Set(k, v)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// UserAgent sets the User-Agent of requests that don't set one.
+func UserAgent(ua string) Middleware {
+	return Headers(map[string]string{"User-Agent": ua})
+}
+
+// Cookies adds the cookies in a cookie string ("name=value; name2=value2")
+// to every request that doesn't already carry a cookie of the same name,
+// e.g. from a cookie jar. They are not sent after a redirect to another
+// domain. An unparsable string adds nothing.
+func Cookies(cookies string) Middleware {
+	parsed, _ := http.ParseCookie(cookies)
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if redirectedAway(req) {
+				return next.RoundTrip(req)
+			}
+			var cloned bool
+			for _, c := range parsed {
+				if _, err := req.Cookie(c.Name); err == nil {
+					continue
+				}
+				if !cloned {
+					req, cloned = req.Clone(req.Context()), true
+				}
+				req.AddCookie(c)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// sensitiveHeaders are the headers net/http drops when following a
+// redirect to another domain.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Www-Authenticate":    true,
+	"Cookie":              true,
+	"Cookie2":             true,
+}
+
+// redirectedAway reports whether req follows a redirect to a host that is
+// neither the first request's host nor a subdomain of it. Middleware runs
+// again for every redirect hop, so it has to apply net/http's rule itself.
+func redirectedAway(req *http.Request) bool {
+	first := req
+	for first.Response != nil && first.Response.Request != nil {
+		first = first.Response.Request
+	}
+	if first == req {
+		return false
+	}
+	host, origin := strings.ToLower(req.URL.Hostname()), strings.ToLower(first.URL.Hostname())
+	return host != origin && !strings.HasSuffix(host, "."+origin)
+}
+
+// RateLimit limits how fast response bodies are read, across all requests
+// sharing the limiter.
+func RateLimit(limiter *rate.Limiter) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			resp.Body = &rateLimitedReader{r: resp.Body, limiter: limiter, ctx: req.Context()}
+			return resp, nil
+		})
+	}
+}
+
+// rateLimitedReader wraps an io.ReadCloser with rate limiting.
+type rateLimitedReader struct {
+	r       io.ReadCloser
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	// Read at most a burst, then wait for the bytes actually read, so a
+	// short body isn't held back for a full buffer at low rates
+	if b := r.limiter.Burst(); len(p) > b {
+		p = p[:b]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (r *rateLimitedReader) Close() error {
+	return r.r.Close()
+}
+
+// ErrStalled is returned for a request that received no data for longer
+// than its StallTimeout. It is retryable.
+var ErrStalled = errors.New("connection stalled")
+
+// StallTimeout fails a request once no data arrives for d: while
+// connecting, waiting for the response headers, or between reads of the
+// body. Unlike http.Client.Timeout it doesn't limit the total time, so
+// large or rate-limited bodies still complete.
+func StallTimeout(d time.Duration) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			stalled := fmt.Errorf("%w: no data for %s", ErrStalled, d)
+			ctx, cancel := context.WithCancelCause(req.Context())
+			timer := time.AfterFunc(d, func() { cancel(stalled) })
+			resp, err := next.RoundTrip(req.WithContext(ctx))
+			if err != nil {
+				timer.Stop()
+				cancel(nil)
+				if context.Cause(ctx) == stalled {
+					err = stalled
+				}
+				return nil, err
+			}
+			timer.Reset(d)
+			resp.Body = &stallReader{ReadCloser: resp.Body, ctx: ctx, cancel: cancel, timer: timer, d: d, stalled: stalled}
+			return resp, nil
+		})
+	}
+}
+
+// stallReader restarts its timer whenever body data arrives.
+type stallReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	d       time.Duration
+	stalled error
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.d)
+	}
+	if err != nil && err != io.EOF && context.Cause(r.ctx) == r.stalled {
+		err = r.stalled
+	}
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	r.cancel(nil)
+	return r.ReadCloser.Close()
+}
+
+// Logging logs every request at debug level with its status (or error)
+// and time to the response headers. Query strings are left out, since they
+// often hold signatures or tokens.
+func Logging(log *slog.Logger) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !log.Enabled(req.Context(), slog.LevelDebug) {
+				return next.RoundTrip(req)
+			}
+			start := time.Now()
+			resp, err := next.RoundTrip(req)
+			elapsed := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				log.DebugContext(req.Context(), "request failed", "method", req.Method, "url", RedactURL(req.URL.String()),
+					"error", err, "duration", elapsed)
+			} else {
+				log.DebugContext(req.Context(), "request", "method", req.Method, "url", RedactURL(req.URL.String()),
+					"status", resp.StatusCode, "duration", elapsed)
+			}
+			return resp, err
+		})
+	}
+}
+
+// RedactURL returns rawURL without its query and user info, which often
+// hold signatures, tokens or passwords.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	u.RawQuery, u.User, u.ForceQuery = "", nil, false
+	return u.String()
+}
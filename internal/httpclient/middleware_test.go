@@ -0,0 +1,198 @@
+package httpclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestClientMiddleware(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer srv.Close()
+
+	var logged bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.Headers = map[string]string{"Referer": "https://example.com/", "X-Token": "abc"}
+	cfg.Cookies = "session=1; lang=en"
+	cfg.UserAgent = "veld-test"
+	cfg.Logger = slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := New(cfg)
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/seg.ts?sig=secret", nil)
+	req.Header.Set("X-Token", "per-request")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for k, want := range map[string]string{
+		"Referer":    "https://example.com/",
+		"X-Token":    "per-request", // The request's own header wins
+		"Cookie":     "session=1; lang=en",
+		"User-Agent": "veld-test",
+	} {
+		if v := got.Get(k); v != want {
+			t.Errorf("%s = %q, want %q", k, v, want)
+		}
+	}
+	if req.Header.Get("Referer") != "" {
+		t.Error("middleware modified the caller's request")
+	}
+	if line := logged.String(); strings.Count(line, "\n") != 1 || !strings.Contains(line, "/seg.ts status=200") || strings.Contains(line, "secret") {
+		t.Errorf("logged %q", line)
+	}
+}
+
+func TestClientWrapsBase(t *testing.T) {
+	var sawBase bool
+	base := &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sawBase = req.Header.Get("User-Agent") == "custom"
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})}
+
+	cfg := DefaultConfig()
+	cfg.Base = base
+	cfg.UserAgent = "custom"
+	resp, err := New(cfg).Get("http://media.example/manifest.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !sawBase {
+		t.Error("request did not reach the base transport with the user agent")
+	}
+	if _, ok := base.Transport.(RoundTripperFunc); !ok {
+		t.Error("base client was modified")
+	}
+}
+
+func TestClientDropsCredentialsOnCrossDomainRedirect(t *testing.T) {
+	got := map[string]http.Header{}
+	var port string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got[r.Host] = r.Header.Clone()
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "http://cdn.origin.test:"+port+"/other", http.StatusFound)
+		case "/other":
+			http.Redirect(w, r, "http://elsewhere.test:"+port+"/seg.ts", http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	_, port, _ = net.SplitHostPort(srv.Listener.Addr().String())
+
+	cfg := DefaultConfig()
+	cfg.Headers = map[string]string{"Authorization": "Bearer abc", "Referer": "https://example.com/"}
+	cfg.Cookies = "session=1"
+	cfg.Resolve = map[string]string{}
+	for _, host := range []string{"origin.test", "cdn.origin.test", "elsewhere.test"} {
+		cfg.Resolve[net.JoinHostPort(host, port)] = "127.0.0.1"
+	}
+	resp, err := New(cfg).Get("http://origin.test:" + port + "/same")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	for host, want := range map[string]bool{"origin.test": true, "cdn.origin.test": true, "elsewhere.test": false} {
+		h := got[net.JoinHostPort(host, port)]
+		if h == nil {
+			t.Fatalf("no request to %s", host)
+		}
+		if has := h.Get("Authorization") != "" || h.Get("Cookie") != ""; has != want {
+			t.Errorf("%s: credentials sent = %v, want %v", host, has, want)
+		}
+		if h.Get("Referer") == "" {
+			t.Errorf("%s: Referer missing", host)
+		}
+	}
+}
+
+func TestRateLimitWaitsForBytesRead(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Repeat([]byte{'x'}, len(r.URL.Query().Get("n"))*100))
+	}))
+	defer srv.Close()
+	// 1KB/s with a 100 byte burst
+	client := &http.Client{Transport: Chain(http.DefaultTransport, RateLimit(rate.NewLimiter(1000, 100)))}
+	get := func(n string) {
+		resp, err := client.Get(srv.URL + "/?n=" + n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+
+	// Short bodies only use up the bytes they return, not a whole burst
+	start := time.Now()
+	get("")
+	get("")
+	get("1")
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("100 bytes within the burst took %v", d)
+	}
+	start = time.Now()
+	get("111")
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("300 bytes at 1KB/s took %v", d)
+	}
+}
+
+func TestStallTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pause := 20 * time.Millisecond
+		if r.URL.Path == "/stall" {
+			pause = time.Second
+		}
+		for range 5 {
+			w.Write([]byte("chunk"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(pause):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	cfg := DefaultConfig()
+	cfg.StallTimeout = 200 * time.Millisecond
+	client := New(cfg)
+
+	// Slower overall than the timeout, but never silent for that long
+	resp, err := client.Get(srv.URL + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || len(body) != 25 {
+		t.Errorf("slow body: %d bytes, %v", len(body), err)
+	}
+
+	resp, err = client.Get(srv.URL + "/stall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !errors.Is(err, ErrStalled) || !IsRetryable(err) {
+		t.Errorf("stalled body: err = %v, want retryable ErrStalled", err)
+	}
+}
@@ -0,0 +1,196 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // Total attempts including the first; 1 disables retries
+	BaseDelay   time.Duration // Backoff before the first retry, doubled on each retry
+	MaxDelay    time.Duration // Cap for a single backoff
+	MaxElapsed  time.Duration // Give up once retrying would exceed this, 0 = no limit
+	Jitter      float64       // Fraction of each backoff that is randomized (0-1)
+
+	// OnRetry is called before waiting to retry, e.g. for logging.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured.
+func DefaultRetryPolicy() RetryPolicy {
+	return NewRetryPolicy(3, time.Second, 2*time.Minute)
+}
+
+// NewRetryPolicy returns a policy that retries up to retries times, starting
+// at delay and giving up after maxElapsed (0 = no limit).
+func NewRetryPolicy(retries int, delay, maxElapsed time.Duration) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: retries + 1,
+		BaseDelay:   delay,
+		MaxDelay:    30 * time.Second,
+		MaxElapsed:  maxElapsed,
+		Jitter:      0.5,
+	}
+}
+
+// Do calls fn until it succeeds, returns an error that is not retryable,
+// or the policy gives up. fn receives the zero-based attempt number.
+func (p RetryPolicy) Do(ctx context.Context, fn func(attempt int) error) error {
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !IsRetryable(err) || attempt+1 >= p.MaxAttempts {
+			return withAttempts(err, attempt+1)
+		}
+
+		delay := p.backoff(attempt, err)
+		if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+			return withAttempts(err, attempt+1)
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt+1, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return withAttempts(err, attempt+1)
+		}
+	}
+}
+
+// Logged returns a copy of the policy that logs each retry to log at debug
+// level, with attrs and the attempt, error and delay.
+func (p RetryPolicy) Logged(log *slog.Logger, attrs ...any) RetryPolicy {
+	p.OnRetry = func(attempt int, err error, delay time.Duration) {
+		log.Debug("retrying", slices.Concat(attrs, []any{"attempt", attempt, "error", err, "delay", delay.Round(time.Millisecond)})...)
+	}
+	return p
+}
+
+// backoff returns the wait before the next attempt. A Retry-After from the
+// server is honored as-is; otherwise the delay grows exponentially with
+// jitter.
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > 0 {
+		return se.RetryAfter
+	}
+
+	// Compare before shifting so doubling can't overflow; a zero base
+	// delay stays zero
+	limit := p.MaxDelay
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	shift := min(attempt, 63)
+	delay := p.BaseDelay
+	if delay > limit>>shift {
+		delay = limit
+	} else {
+		delay <<= shift
+	}
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+	return delay
+}
+
+func withAttempts(err error, attempts int) error {
+	if attempts <= 1 {
+		return err
+	}
+	return fmt.Errorf("%w (after %d attempts)", err, attempts)
+}
+
+// StatusError is an unsuccessful HTTP response.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP %d", e.StatusCode)
+}
+
+// Retryable reports whether the status is likely to succeed on retry.
+func (e *StatusError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return e.StatusCode >= 500
+}
+
+// CheckResponse returns a *StatusError unless the response is 200 or 206.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+		return nil
+	}
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// parseRetryAfter parses a Retry-After value in seconds or as an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return max(time.Duration(secs)*time.Second, 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// permanentError marks an error that must not be retried.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so that RetryPolicy.Do gives up immediately.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsRetryable reports whether an error is worth retrying. Client errors
+// such as 403/404/410, cancellation and errors wrapped with Permanent are
+// not; network errors, timeouts and server errors are.
+func IsRetryable(err error) bool {
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Retryable()
+	}
+	return true
+}
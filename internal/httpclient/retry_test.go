@@ -0,0 +1,118 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&StatusError{StatusCode: 404}, false},
+		{&StatusError{StatusCode: 403}, false},
+		{&StatusError{StatusCode: 429}, true},
+		{&StatusError{StatusCode: 408}, true},
+		{&StatusError{StatusCode: 503}, true},
+		{&StatusError{StatusCode: 501}, false},
+		{fmt.Errorf("wrapped: %w", &StatusError{StatusCode: 502}), true},
+		{errors.New("connection reset"), true},
+		{context.Canceled, false},
+		{Permanent(errors.New("bad key")), false},
+	}
+	for _, tt := range tests {
+		if got := IsRetryable(tt.err); got != tt.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"5", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.in, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(int) error {
+		calls++
+		if calls < 3 {
+			return &StatusError{StatusCode: 503}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("503 then success: err=%v calls=%d, want nil and 3", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), func(int) error {
+		calls++
+		return &StatusError{StatusCode: 404}
+	})
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != 404 || calls != 1 {
+		t.Errorf("404: err=%v calls=%d, want HTTP 404 after 1 call", err, calls)
+	}
+
+	calls = 0
+	err = policy.Do(context.Background(), func(int) error {
+		calls++
+		return &StatusError{StatusCode: 500}
+	})
+	if err == nil || calls != 4 {
+		t.Errorf("500: err=%v calls=%d, want error after 4 calls", err, calls)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+	for attempt, want := range map[int]time.Duration{0: time.Second, 3: 8 * time.Second, 5: 30 * time.Second, 70: 30 * time.Second} {
+		if got := policy.backoff(attempt, nil); got != want {
+			t.Errorf("attempt %d: backoff %s, want %s", attempt, got, want)
+		}
+	}
+
+	// --retry-delay 0 retries right away instead of waiting MaxDelay
+	policy.BaseDelay = 0
+	for _, attempt := range []int{0, 1, 20, 70} {
+		if got := policy.backoff(attempt, nil); got != 0 {
+			t.Errorf("zero delay, attempt %d: backoff %s, want 0", attempt, got)
+		}
+	}
+}
+
+func TestRetryPolicyMaxElapsed(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Millisecond, MaxElapsed: 50 * time.Millisecond}
+
+	calls := 0
+	err := policy.Do(context.Background(), func(int) error {
+		calls++
+		return &StatusError{StatusCode: 429, RetryAfter: time.Hour}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Retry-After beyond MaxElapsed: err=%v calls=%d, want error after 1 call", err, calls)
+	}
+}
@@ -0,0 +1,112 @@
+package httpclient
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BandwidthWindow limits bandwidth during a daily time window, in local
+// time. A window whose end is before its start runs past midnight.
+type BandwidthWindow struct {
+	Start, End  time.Duration // Since midnight, End up to 24h
+	BytesPerSec int64         // 0 = unlimited
+}
+
+// contains reports whether the time of day d is inside the window.
+func (w BandwidthWindow) contains(d time.Duration) bool {
+	if w.Start < w.End {
+		return d >= w.Start && d < w.End
+	}
+	return d >= w.Start || d < w.End
+}
+
+func (w BandwidthWindow) String() string {
+	rate := "unlimited"
+	if w.BytesPerSec > 0 {
+		rate = strconv.FormatInt(w.BytesPerSec, 10)
+	}
+	return fmt.Sprintf("%s-%s=%s", formatClock(w.Start), formatClock(w.End), rate)
+}
+
+// ParseBandwidthSchedule parses comma-separated windows like
+// "00:00-07:00=unlimited,09:00-18:00=2M". Rates are as in ParseRate;
+// "unlimited" or 0 lifts the limit. The first matching window applies.
+func ParseBandwidthSchedule(s string) ([]BandwidthWindow, error) {
+	var windows []BandwidthWindow
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		span, rate, ok := strings.Cut(part, "=")
+		from, to, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid bandwidth window %q (want HH:MM-HH:MM=RATE)", part)
+		}
+
+		var w BandwidthWindow
+		var err error
+		if w.Start, err = parseClock(from); err != nil {
+			return nil, fmt.Errorf("bandwidth window %q: %w", part, err)
+		}
+		if w.End, err = parseClock(to); err != nil {
+			return nil, fmt.Errorf("bandwidth window %q: %w", part, err)
+		}
+		if w.Start == 24*time.Hour || w.Start == w.End {
+			return nil, fmt.Errorf("bandwidth window %q is empty", part)
+		}
+		if rate != "unlimited" {
+			if w.BytesPerSec, err = ParseRate(rate); err != nil {
+				return nil, fmt.Errorf("bandwidth window %q: %w", part, err)
+			}
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseClock parses "HH:MM" (00:00 to 24:00) into the time since midnight.
+func parseClock(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, err1 := strconv.Atoi(h)
+	mins, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || mins < 0 || mins > 59 ||
+		hours > 24 || (hours == 24 && mins > 0) {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(mins)*time.Minute, nil
+}
+
+func formatClock(d time.Duration) string {
+	return fmt.Sprintf("%02d:%02d", int(d/time.Hour), int(d%time.Hour/time.Minute))
+}
+
+// scheduledLimit returns the limit of the first window containing t, or
+// def if none does.
+func scheduledLimit(windows []BandwidthWindow, def int64, t time.Time) int64 {
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	for _, w := range windows {
+		if w.contains(d) {
+			return w.BytesPerSec
+		}
+	}
+	return def
+}
+
+// nextBoundary returns the next time after t at which a window starts or
+// ends.
+func nextBoundary(windows []BandwidthWindow, t time.Time) time.Time {
+	var next time.Time
+	for _, w := range windows {
+		for _, b := range []time.Duration{w.Start, w.End} {
+			at := time.Date(t.Year(), t.Month(), t.Day(), int(b/time.Hour), int(b%time.Hour/time.Minute), 0, 0, t.Location())
+			if !at.After(t) {
+				at = at.AddDate(0, 0, 1)
+			}
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+	}
+	return next
+}
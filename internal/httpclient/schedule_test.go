@@ -0,0 +1,100 @@
+package httpclient
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	windows, err := ParseBandwidthSchedule("00:00-07:00=unlimited, 09:00-18:00=2M,22:30-02:00=500K")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BandwidthWindow{
+		{Start: 0, End: 7 * time.Hour, BytesPerSec: 0},
+		{Start: 9 * time.Hour, End: 18 * time.Hour, BytesPerSec: 2 << 20},
+		{Start: 22*time.Hour + 30*time.Minute, End: 2 * time.Hour, BytesPerSec: 500 << 10},
+	}
+	if len(windows) != len(want) {
+		t.Fatalf("got %v, want %v", windows, want)
+	}
+	for i := range want {
+		if windows[i] != want[i] {
+			t.Errorf("window %d = %v, want %v", i, windows[i], want[i])
+		}
+	}
+
+	// WithBandwidthSchedule passes windows on in their String form
+	specs := make([]string, len(want))
+	for i, w := range want {
+		specs[i] = w.String()
+	}
+	if again, err := ParseBandwidthSchedule(strings.Join(specs, ",")); err != nil || !slices.Equal(again, want) {
+		t.Errorf("reparsed %q = %v, %v", strings.Join(specs, ","), again, err)
+	}
+
+	for _, s := range []string{"", "09:00-18:00", "9-18=1M", "09:00-09:00=1M", "24:00-01:00=1M", "09:60-10:00=1M", "09:00-25:00=1M", "09:00-18:00=fast"} {
+		if _, err := ParseBandwidthSchedule(s); err == nil {
+			t.Errorf("ParseBandwidthSchedule(%q) accepted", s)
+		}
+	}
+}
+
+func TestScheduledLimit(t *testing.T) {
+	windows, _ := ParseBandwidthSchedule("09:00-18:00=2M,22:00-07:00=unlimited")
+	day := func(h, m int) time.Time { return time.Date(2025, 3, 10, h, m, 0, 0, time.Local) }
+
+	for _, tc := range []struct {
+		at   time.Time
+		want int64
+	}{
+		{day(8, 59), 100},
+		{day(9, 0), 2 << 20},
+		{day(17, 59), 2 << 20},
+		{day(18, 0), 100},
+		{day(23, 0), 0},
+		{day(3, 0), 0},
+		{day(7, 0), 100},
+	} {
+		if got := scheduledLimit(windows, 100, tc.at); got != tc.want {
+			t.Errorf("limit at %s = %d, want %d", tc.at.Format("15:04"), got, tc.want)
+		}
+	}
+
+	if got, want := nextBoundary(windows, day(8, 0)), day(9, 0); !got.Equal(want) {
+		t.Errorf("next boundary after 08:00 = %s, want %s", got, want)
+	}
+	if got, want := nextBoundary(windows, day(22, 0)), day(31, 0); !got.Equal(want) { // 07:00 the next day
+		t.Errorf("next boundary after 22:00 = %s, want %s", got, want)
+	}
+}
+
+func TestBudgetFollowsSchedule(t *testing.T) {
+	window := BandwidthWindow{Start: 9 * time.Hour, End: 18 * time.Hour, BytesPerSec: 1000}
+
+	b := NewBudget(5000)
+	b.now = func() time.Time { return time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local) }
+	defer b.Stop()
+	s := b.Share(1)
+	b.SetSchedule([]BandwidthWindow{window})
+	if got := s.Rate(); got != 1000 {
+		t.Errorf("rate inside window = %d, want 1000", got)
+	}
+	if got := b.Limit(); got != 5000 {
+		t.Errorf("Limit() = %d, want the default 5000", got)
+	}
+
+	b.now = func() time.Time { return time.Date(2025, 3, 10, 18, 0, 0, 0, time.Local) }
+	b.SetLimit(5000)
+	if got := s.Rate(); got != 5000 {
+		t.Errorf("rate after the window = %d, want 5000", got)
+	}
+	b.SetSchedule([]BandwidthWindow{window})
+
+	b.SetSchedule(nil)
+	if got := s.Rate(); got != 5000 {
+		t.Errorf("rate without schedule = %d, want 5000", got)
+	}
+}
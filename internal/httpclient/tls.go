@@ -0,0 +1,151 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// TLSOptions configures TLS for all connections.
+type TLSOptions struct {
+	CAFile   string // PEM bundle of root CAs trusted besides the system's
+	CertFile string // PEM client certificate for mutual TLS
+	KeyFile  string // PEM key of the client certificate
+	Insecure bool   // Skip certificate verification
+}
+
+// HostOverride changes the names requests to one host present, e.g. to
+// test an origin pinned with Resolve under the name it serves.
+type HostOverride struct {
+	ServerName string // SNI and certificate name, "" = the URL's host
+	Host       string // Host header, "" = the URL's host
+}
+
+// NewTLSConfig loads the files named in opts and returns the TLS config
+// for a Config.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.Insecure,
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in CA file %s", opts.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		if opts.CertFile == "" || opts.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate needs both a certificate and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ParseResolve parses a curl-style "host:port:address" override, which
+// makes connections to host:port go to address instead. The URL, Host
+// header and SNI keep the original host.
+func ParseResolve(s string) (hostPort, addr string, err error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid resolve %q (want host:port:address)", s)
+	}
+	ip := strings.TrimSuffix(strings.TrimPrefix(parts[2], "["), "]")
+	if net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid resolve %q: %q is not an IP address", s, parts[2])
+	}
+	return net.JoinHostPort(parts[0], parts[1]), ip, nil
+}
+
+// HostPort returns u's "host:port", with the scheme's default port if u
+// has none. Resolve and Overrides are keyed by it.
+func HostPort(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// overridingTLSDialer returns a DialTLSContext for t that sends the
+// overridden server name to hosts in overrides, or nil if none overrides
+// it. t.TLSClientConfig is read on every dial, as the transport adds the
+// HTTP/2 protocol to it.
+func overridingTLSDialer(t *http.Transport, overrides map[string]HostOverride) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var overridden bool
+	for _, o := range overrides {
+		overridden = overridden || o.ServerName != ""
+	}
+	if !overridden {
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cfg := t.TLSClientConfig.Clone()
+		if name := overrides[addr].ServerName; name != "" {
+			cfg.ServerName = name
+		} else {
+			cfg.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn, err := t.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// hostHeaders sets the Host header of requests to hosts in overrides.
+func hostHeaders(overrides map[string]HostOverride) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if host := overrides[HostPort(req.URL)].Host; host != "" && req.Host != host {
+				req = req.Clone(req.Context())
+				req.Host = host
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// resolvingDialer dials the overridden address for hosts in resolve.
+func resolvingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error), resolve map[string]string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if len(resolve) == 0 {
+		return dial
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if ip, ok := resolve[addr]; ok {
+			_, port, _ := net.SplitHostPort(addr)
+			addr = net.JoinHostPort(ip, port)
+		}
+		return dial(ctx, network, addr)
+	}
+}
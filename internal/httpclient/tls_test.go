@@ -0,0 +1,161 @@
+package httpclient
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSPrivateCAClientCertAndResolve(t *testing.T) {
+	var clientCN string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		io.WriteString(w, r.Host)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // The untrusted case fails the handshake
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	certFile, keyFile := writeClientCert(t, dir)
+
+	// httptest's certificate is valid for example.com; pin it to the server
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	hostPort, addr, err := ParseResolve("example.com:" + port + ":127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsCfg, err := NewTLSConfig(TLSOptions{CAFile: caFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := DefaultConfig()
+	cfg.TLS = tlsCfg
+	cfg.Resolve = map[string]string{hostPort: addr}
+
+	resp, err := New(cfg).Get("https://" + hostPort + "/manifest.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != hostPort || clientCN != "veld-test" {
+		t.Errorf("server saw Host %q and client %q", body, clientCN)
+	}
+
+	// Without the CA the private certificate is rejected
+	cfg.TLS, _ = NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile})
+	if _, err := New(cfg).Get("https://" + hostPort + "/"); err == nil {
+		t.Error("untrusted certificate accepted")
+	}
+	cfg.TLS, _ = NewTLSConfig(TLSOptions{CertFile: certFile, KeyFile: keyFile, Insecure: true})
+	if _, err := New(cfg).Get("https://" + hostPort + "/"); err != nil {
+		t.Errorf("insecure: %v", err)
+	}
+}
+
+func TestHostOverrideOnlyForItsHost(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.ServerName+" "+r.Host)
+	}))
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", srv.Certificate().Raw)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	cfg := DefaultConfig()
+	cfg.TLS, _ = NewTLSConfig(TLSOptions{CAFile: caFile})
+	cfg.Resolve = map[string]string{"staging.test:" + port: "127.0.0.1"}
+	cfg.Overrides = map[string]HostOverride{"staging.test:" + port: {ServerName: "example.com", Host: "www.example.com"}}
+	client := New(cfg)
+
+	for url, want := range map[string]string{
+		// httptest's certificate is valid for example.com and 127.0.0.1
+		"https://staging.test:" + port + "/": "example.com www.example.com",
+		"https://127.0.0.1:" + port + "/":    " 127.0.0.1:" + port,
+	} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("%s: server saw SNI and Host %q, want %q", url, body, want)
+		}
+	}
+}
+
+func TestTLSOptionErrors(t *testing.T) {
+	for _, opts := range []TLSOptions{
+		{CAFile: "/nonexistent/ca.pem"},
+		{CertFile: "cert.pem"},
+		{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"},
+	} {
+		if _, err := NewTLSConfig(opts); err == nil {
+			t.Errorf("NewTLSConfig(%+v) accepted", opts)
+		}
+	}
+	for _, r := range []string{"example.com:443", "example.com:443:cdn.example", ":443:1.2.3.4"} {
+		if _, _, err := ParseResolve(r); err == nil {
+			t.Errorf("ParseResolve(%q) accepted", r)
+		}
+	}
+	if hp, addr, err := ParseResolve("example.com:443:[::1]"); err != nil || hp != "example.com:443" || addr != "::1" {
+		t.Errorf("ParseResolve IPv6 = %q, %q, %v", hp, addr, err)
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key.
+func writeClientCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "veld-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// EventType classifies a timed manifest event.
+type EventType int
+
+const (
+	EventMarker EventType = iota
+	EventAdBreak
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventMarker:
+		return "marker"
+	case EventAdBreak:
+		return "ad"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a timed marker from the manifest, such as an HLS EXT-X-DATERANGE,
+// an EXT-X-CUE-OUT/CUE-IN pair or a DASH EventStream entry.
+type Event struct {
+	ID       string
+	Type     EventType
+	Class    string        // DATERANGE CLASS or EventStream schemeIdUri
+	Start    time.Duration // Presentation time relative to the stream start
+	Duration time.Duration // 0 if unknown
+	SCTE35   string        // Raw SCTE-35 payload (hex or base64) when present
+
+	Attributes map[string]string
+}
+
+// End returns the presentation time at which the event ends.
+func (e *Event) End() time.Duration {
+	return e.Start + e.Duration
+}
+
+// IsAdBreak returns true if the event marks an ad break.
+func (e *Event) IsAdBreak() bool {
+	return e.Type == EventAdBreak
+}
+
+// Contains reports whether t falls inside the event's time span.
+func (e *Event) Contains(t time.Duration) bool {
+	return t >= e.Start && t < e.End()
+}
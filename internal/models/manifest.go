@@ -34,6 +34,35 @@ type Manifest struct {
 	Type     ManifestType
 	Tracks   []*Track
 	Duration time.Duration
+	Events   []*Event // Ad markers and other timed metadata
+
+	// DASH live metadata
+	Live                 bool // MPD@type="dynamic"
+	TimeShiftBufferDepth time.Duration
+	MinimumUpdatePeriod  time.Duration
+
+	// HLS master playlist metadata
+	SessionData []SessionData
+	SessionKeys []string // AES-128 key URIs from EXT-X-SESSION-KEY, for prefetching
+}
+
+// SessionData is an EXT-X-SESSION-DATA entry (e.g. title or language metadata).
+type SessionData struct {
+	ID       string // DATA-ID, e.g. "com.example.title"
+	Value    string
+	URI      string // JSON document with the value, if VALUE is absent
+	Language string
+}
+
+// AdBreaks returns the manifest events that mark ad breaks.
+func (m *Manifest) AdBreaks() []*Event {
+	var breaks []*Event
+	for _, e := range m.Events {
+		if e.IsAdBreak() && e.Duration > 0 {
+			breaks = append(breaks, e)
+		}
+	}
+	return breaks
 }
 
 // TrackType represents the type of media track.
@@ -43,6 +72,7 @@ const (
 	TrackVideo TrackType = iota
 	TrackAudio
 	TrackSubtitle
+	TrackThumbnail // I-frame (trick-play) or image tile track
 )
 
 func (t TrackType) String() string {
@@ -53,12 +83,14 @@ func (t TrackType) String() string {
 		return "audio"
 	case TrackSubtitle:
 		return "subtitle"
+	case TrackThumbnail:
+		return "thumbnail"
 	default:
 		return "unknown"
 	}
 }
 
-// Track represents a media track (video, audio, or subtitle).
+// Track represents a media track (video, audio, subtitle or thumbnail).
 type Track struct {
 	ID          string
 	Type        TrackType
@@ -72,6 +104,10 @@ type Track struct {
 
 	// Media playlist URL for lazy loading (HLS audio/subtitle tracks)
 	MediaPlaylistURL string
+	PlaylistVars     map[string]string // EXT-X-DEFINE variables the playlist may IMPORT
+
+	// Offset into the first segment where a clipped time range begins
+	ClipStart time.Duration
 
 	// Encryption info
 	Decryptor     *decryptor.Decryptor    // For CENC (DASH)
@@ -84,6 +120,9 @@ type Track struct {
 
 // IsVideo returns true if track is a video track.
 func (t *Track) IsVideo() bool {
+	if t.Type == TrackThumbnail {
+		return false
+	}
 	if t.Type == TrackVideo {
 		return true
 	}
@@ -109,6 +148,17 @@ func (t *Track) IsSubtitle() bool {
 	return hasSubtitleCodec(t.Codec)
 }
 
+// IsThumbnail returns true if track is an I-frame or image thumbnail track.
+func (t *Track) IsThumbnail() bool {
+	return t.Type == TrackThumbnail
+}
+
+// IsImage returns true if the track's segments are still images (tiles)
+// rather than I-frame video.
+func (t *Track) IsImage() bool {
+	return t.IsThumbnail() && hasImageCodec(t.Codec)
+}
+
 // Resolution represents video dimensions.
 type Resolution struct {
 	Width  int
@@ -148,6 +198,7 @@ func (r Resolution) QualityLabel() string {
 type Segment struct {
 	Index     int
 	URL       string
+	Start     time.Duration // Presentation time within the stream
 	Duration  time.Duration
 	Size      int64
 	ByteRange *ByteRange
@@ -166,6 +217,7 @@ var (
 	audioCodecs    = []string{"mp4a", "aac", "ac-3", "ec-3", "opus", "vorbis", "flac", "mp3"}
 	videoCodecs    = []string{"avc", "h264", "hevc", "h265", "hvc1", "hev1", "vp9", "vp8", "av01", "av1"}
 	subtitleCodecs = []string{"stpp", "wvtt", "ttml", "webvtt", "vtt", "srt"}
+	imageCodecs    = []string{"jpeg", "jpg", "png", "webp"}
 )
 
 func hasAudioCodec(codec string) bool {
@@ -198,6 +250,16 @@ func hasSubtitleCodec(codec string) bool {
 	return false
 }
 
+func hasImageCodec(codec string) bool {
+	codec = strings.ToLower(codec)
+	for _, ic := range imageCodecs {
+		if strings.Contains(codec, ic) {
+			return true
+		}
+	}
+	return false
+}
+
 // HasAudioCodec is exported for use by other packages.
 func HasAudioCodec(codec string) bool { return hasAudioCodec(codec) }
 
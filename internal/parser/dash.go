@@ -4,7 +4,8 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
-	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -12,21 +13,40 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/models"
 )
 
 // DASHParser parses DASH (mpd) manifests.
 type DASHParser struct {
 	client *http.Client
+	retry  httpclient.RetryPolicy
+	log    *slog.Logger
 }
 
 // NewDASHParser creates a new DASH parser.
 func NewDASHParser() *DASHParser {
 	return &DASHParser{
-		client: &http.Client{Timeout: 30 * time.Second},
+		client: defaultClient(),
+		retry:  httpclient.DefaultRetryPolicy(),
 	}
 }
 
+// SetClient sets the HTTP client used to fetch manifests.
+func (p *DASHParser) SetClient(client *http.Client) {
+	p.client = client
+}
+
+// SetRetryPolicy sets how failed manifest fetches are retried.
+func (p *DASHParser) SetRetryPolicy(policy httpclient.RetryPolicy) {
+	p.retry = policy
+}
+
+// SetLogger sets the logger for fetch retries.
+func (p *DASHParser) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
 // CanParse checks if URL is a DASH manifest.
 func (p *DASHParser) CanParse(urlStr string) bool {
 	lower := strings.ToLower(urlStr)
@@ -54,8 +74,11 @@ func (p *DASHParser) Parse(ctx context.Context, urlStr string, headers map[strin
 
 type MPD struct {
 	XMLName                   xml.Name `xml:"MPD"`
+	Type                      string   `xml:"type,attr"`
 	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr"`
 	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth      string   `xml:"timeShiftBufferDepth,attr"`
+	MinimumUpdatePeriod       string   `xml:"minimumUpdatePeriod,attr"`
 	Periods                   []Period `xml:"Period"`
 	BaseURL                   string   `xml:"BaseURL"`
 }
@@ -65,9 +88,26 @@ type Period struct {
 	Start          string          `xml:"start,attr"`
 	Duration       string          `xml:"duration,attr"`
 	AdaptationSets []AdaptationSet `xml:"AdaptationSet"`
+	EventStreams   []EventStream   `xml:"EventStream"`
 	BaseURL        string          `xml:"BaseURL"`
 }
 
+type EventStream struct {
+	SchemeIdUri            string      `xml:"schemeIdUri,attr"`
+	Value                  string      `xml:"value,attr"`
+	Timescale              int64       `xml:"timescale,attr"`
+	PresentationTimeOffset int64       `xml:"presentationTimeOffset,attr"`
+	Events                 []DASHEvent `xml:"Event"`
+}
+
+type DASHEvent struct {
+	ID               string `xml:"id,attr"`
+	PresentationTime int64  `xml:"presentationTime,attr"`
+	Duration         int64  `xml:"duration,attr"`
+	MessageData      string `xml:"messageData,attr"`
+	Content          string `xml:",innerxml"`
+}
+
 type AdaptationSet struct {
 	ID                 string              `xml:"id,attr"`
 	MimeType           string              `xml:"mimeType,attr"`
@@ -134,17 +174,39 @@ type ContentProtection struct {
 // convertMPD converts parsed MPD to our manifest model.
 func (p *DASHParser) convertMPD(mpd *MPD, baseURL *url.URL) (*models.Manifest, error) {
 	manifest := &models.Manifest{
-		URL:      baseURL.String(),
-		Type:     models.ManifestDASH,
-		Duration: parseDuration(mpd.MediaPresentationDuration),
+		URL:  baseURL.String(),
+		Type: models.ManifestDASH,
+		Live: mpd.Type == "dynamic",
+	}
+
+	var err error
+	if manifest.Duration, err = parseDuration(mpd.MediaPresentationDuration); err != nil {
+		return nil, fmt.Errorf("MPD mediaPresentationDuration: %w", err)
+	}
+	if manifest.TimeShiftBufferDepth, err = parseDuration(mpd.TimeShiftBufferDepth); err != nil {
+		return nil, fmt.Errorf("MPD timeShiftBufferDepth: %w", err)
+	}
+	if manifest.MinimumUpdatePeriod, err = parseDuration(mpd.MinimumUpdatePeriod); err != nil {
+		return nil, fmt.Errorf("MPD minimumUpdatePeriod: %w", err)
+	}
+
+	timings, err := periodTimings(mpd.Periods, manifest.Duration)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, period := range mpd.Periods {
+	for pi, period := range mpd.Periods {
+		timing := timings[pi]
 		periodBase := resolveBase(baseURL, mpd.BaseURL, period.BaseURL)
+		manifest.Events = append(manifest.Events, convertEventStreams(period, timing.start)...)
 
 		for _, as := range period.AdaptationSets {
 			asBase := resolveBase(periodBase, as.BaseURL, "")
 			trackType := detectTrackType(as.MimeType, as.ContentType)
+			if trackType == models.TrackVideo && len(as.Representations) > 0 {
+				// mimeType may only be set on the representation (e.g. image/jpeg tiles)
+				trackType = detectTrackType(as.Representations[0].MimeType, as.ContentType)
+			}
 
 			// Check for encryption
 			var keyID string
@@ -162,7 +224,7 @@ func (p *DASHParser) convertMPD(mpd *MPD, baseURL *url.URL) (*models.Manifest, e
 					ID:        rep.ID,
 					Type:      trackType,
 					Bandwidth: rep.Bandwidth,
-					Codec:     firstNonEmpty(rep.Codecs, as.Codecs),
+					Codec:     firstNonEmpty(rep.Codecs, as.Codecs, imageCodec(rep.MimeType, as.MimeType)),
 					Language:  as.Lang,
 					Resolution: models.Resolution{
 						Width:  firstNonZero(rep.Width, as.Width),
@@ -179,7 +241,13 @@ func (p *DASHParser) convertMPD(mpd *MPD, baseURL *url.URL) (*models.Manifest, e
 				}
 
 				if tmpl != nil {
-					track.Segments, track.InitSegment = p.buildSegmentsFromTemplate(tmpl, rep, repBase, manifest.Duration)
+					track.Segments, track.InitSegment, err = p.buildSegmentsFromTemplate(tmpl, rep, repBase, timing.duration)
+					if err != nil {
+						if manifest.Live {
+							err = fmt.Errorf("%w (live MPD without a duration is not supported)", err)
+						}
+						return nil, fmt.Errorf("representation %s: %w", rep.ID, err)
+					}
 				} else if rep.SegmentList != nil {
 					track.Segments, track.InitSegment = p.buildSegmentsFromList(rep.SegmentList, repBase)
 				} else if rep.BaseURL != "" {
@@ -198,8 +266,96 @@ func (p *DASHParser) convertMPD(mpd *MPD, baseURL *url.URL) (*models.Manifest, e
 	return manifest, nil
 }
 
+// scte35BinaryRe extracts the base64 payload of an SCTE-35 XML+bin signal.
+var scte35BinaryRe = regexp.MustCompile(`<(?:\w+:)?Binary>\s*([A-Za-z0-9+/=]+)\s*</(?:\w+:)?Binary>`)
+
+// periodTiming is the resolved start and duration of a Period.
+type periodTiming struct {
+	start    time.Duration
+	duration time.Duration // 0 if unknown
+}
+
+// periodTimings resolves the start and duration of every period. A missing
+// start follows the previous period; a missing duration runs until the next
+// period or the end of the presentation.
+func periodTimings(periods []Period, total time.Duration) ([]periodTiming, error) {
+	timings := make([]periodTiming, len(periods))
+	for i, period := range periods {
+		start, err := parseDuration(period.Start)
+		if err != nil {
+			return nil, fmt.Errorf("period %d start: %w", i, err)
+		}
+		duration, err := parseDuration(period.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("period %d duration: %w", i, err)
+		}
+		if period.Start == "" && i > 0 {
+			start = timings[i-1].start + timings[i-1].duration
+		}
+		timings[i] = periodTiming{start: start, duration: duration}
+	}
+
+	for i := range timings {
+		if timings[i].duration > 0 {
+			continue
+		}
+		end := total
+		if i+1 < len(periods) && periods[i+1].Start != "" {
+			end = timings[i+1].start
+		}
+		if end > timings[i].start {
+			timings[i].duration = end - timings[i].start
+		}
+	}
+	return timings, nil
+}
+
+// scaleTime converts v in units of 1/timescale seconds to a duration.
+// Whole seconds are split off first, so epoch-based values from live
+// streams don't overflow.
+func scaleTime(v, timescale int64) time.Duration {
+	return time.Duration(v/timescale)*time.Second + time.Duration(v%timescale)*time.Second/time.Duration(timescale)
+}
+
+// convertEventStreams converts a period's EventStreams to manifest events.
+func convertEventStreams(period Period, periodStart time.Duration) []*models.Event {
+	var events []*models.Event
+	for _, es := range period.EventStreams {
+		timescale := es.Timescale
+		if timescale == 0 {
+			timescale = 1
+		}
+		isAd := strings.Contains(strings.ToLower(es.SchemeIdUri), "scte35")
+
+		for _, de := range es.Events {
+			ev := &models.Event{
+				ID:       de.ID,
+				Class:    es.SchemeIdUri,
+				Start:    periodStart + scaleTime(de.PresentationTime-es.PresentationTimeOffset, timescale),
+				Duration: scaleTime(de.Duration, timescale),
+				Attributes: map[string]string{
+					"value": es.Value,
+				},
+			}
+			if de.MessageData != "" {
+				ev.Attributes["messageData"] = de.MessageData
+			}
+			if isAd {
+				ev.Type = models.EventAdBreak
+				if m := scte35BinaryRe.FindStringSubmatch(de.Content); m != nil {
+					ev.SCTE35 = m[1]
+				}
+			}
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
 // buildSegmentsFromTemplate generates segments from a template.
-func (p *DASHParser) buildSegmentsFromTemplate(tmpl *SegmentTemplate, rep Representation, base *url.URL, totalDuration time.Duration) ([]*models.Segment, *models.Segment) {
+// Number-based templates without a timeline need the period duration to know
+// how many segments exist.
+func (p *DASHParser) buildSegmentsFromTemplate(tmpl *SegmentTemplate, rep Representation, base *url.URL, periodDuration time.Duration) ([]*models.Segment, *models.Segment, error) {
 	var segments []*models.Segment
 	var initSeg *models.Segment
 
@@ -245,12 +401,15 @@ func (p *DASHParser) buildSegmentsFromTemplate(tmpl *SegmentTemplate, rep Repres
 			}
 		}
 	} else if tmpl.Duration > 0 {
-		// Calculate segment count from manifest duration
+		// Calculate segment count from period duration
 		segmentDuration := time.Duration(tmpl.Duration) * time.Second / time.Duration(timescale)
-		numSegments := 1
-		if totalDuration > 0 && segmentDuration > 0 {
-			numSegments = int(totalDuration/segmentDuration) + 1
+		if segmentDuration <= 0 {
+			return nil, nil, fmt.Errorf("segment duration %d/%d is too small", tmpl.Duration, timescale)
+		}
+		if periodDuration <= 0 {
+			return nil, nil, fmt.Errorf("cannot determine segment count: no mediaPresentationDuration or Period duration")
 		}
+		numSegments := int((periodDuration + segmentDuration - 1) / segmentDuration)
 		for i := 0; i < numSegments; i++ {
 			segNum := tmpl.StartNumber + i
 			mediaURL := expandTemplate(tmpl.Media, rep.ID, segNum, 0)
@@ -263,7 +422,7 @@ func (p *DASHParser) buildSegmentsFromTemplate(tmpl *SegmentTemplate, rep Repres
 		}
 	}
 
-	return segments, initSeg
+	return segments, initSeg, nil
 }
 
 // buildSegmentsFromList builds segments from explicit list.
@@ -297,27 +456,7 @@ func (p *DASHParser) buildSegmentsFromList(list *SegmentList, base *url.URL) ([]
 
 // fetch downloads content from URL.
 func (p *DASHParser) fetch(ctx context.Context, urlStr string, headers map[string]string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		return "", err
-	}
-
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	return string(body), err
+	return fetchText(ctx, p.client, p.retry, p.log, urlStr, headers)
 }
 
 // Helper functions
@@ -331,6 +470,8 @@ func detectTrackType(mimeType, contentType string) models.TrackType {
 		return models.TrackAudio
 	case strings.Contains(check, "text"), strings.Contains(check, "subtitle"):
 		return models.TrackSubtitle
+	case strings.Contains(check, "image"):
+		return models.TrackThumbnail
 	default:
 		return models.TrackVideo
 	}
@@ -365,37 +506,146 @@ func expandTemplate(template string, repID string, number int, t int) string {
 	return result
 }
 
-func parseDuration(s string) time.Duration {
+// durationUnit is one designator of an xs:duration.
+type durationUnit struct {
+	designator byte
+	unit       time.Duration
+}
+
+// Years and months have no fixed length; like most DASH players we count
+// them as 365 and 30 days.
+var (
+	durationDateUnits = []durationUnit{{'Y', 365 * 24 * time.Hour}, {'M', 30 * 24 * time.Hour}, {'D', 24 * time.Hour}}
+	durationTimeUnits = []durationUnit{{'H', time.Hour}, {'M', time.Minute}, {'S', time.Second}}
+)
+
+// parseDuration parses an xs:duration such as "PT1H2M3.5S" or "P1DT2H".
+// An empty string is a zero duration.
+func parseDuration(s string) (time.Duration, error) {
 	if s == "" {
-		return 0
+		return 0, nil
 	}
-	s = strings.TrimPrefix(s, "PT")
-	s = strings.TrimPrefix(s, "P")
 
-	var hours, minutes, seconds float64
+	rest, neg := strings.CutPrefix(s, "-")
+	rest, ok := strings.CutPrefix(rest, "P")
+	if !ok {
+		return 0, fmt.Errorf("invalid duration %q: must start with P", s)
+	}
+	date, clock, hasTime := strings.Cut(rest, "T")
+	if date == "" && clock == "" {
+		return 0, fmt.Errorf("invalid duration %q: no components", s)
+	}
+	if hasTime && clock == "" {
+		return 0, fmt.Errorf("invalid duration %q: T without time components", s)
+	}
 
-	if idx := strings.Index(s, "H"); idx != -1 {
-		hours, _ = strconv.ParseFloat(s[:idx], 64)
-		s = s[idx+1:]
+	d, err := parseDurationComponents(date, durationDateUnits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", s, err)
 	}
-	if idx := strings.Index(s, "M"); idx != -1 {
-		minutes, _ = strconv.ParseFloat(s[:idx], 64)
-		s = s[idx+1:]
+	t, err := parseDurationComponents(clock, durationTimeUnits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", s, err)
 	}
-	if idx := strings.Index(s, "S"); idx != -1 {
-		seconds, _ = strconv.ParseFloat(s[:idx], 64)
+	if d > math.MaxInt64-t {
+		return 0, fmt.Errorf("invalid duration %q: out of range", s)
 	}
 
-	return time.Duration(hours)*time.Hour +
-		time.Duration(minutes)*time.Minute +
-		time.Duration(seconds*float64(time.Second))
+	if neg {
+		return -(d + t), nil
+	}
+	return d + t, nil
 }
 
-func firstNonEmpty(a, b string) string {
-	if a != "" {
-		return a
+// parseDurationComponents sums "<number><designator>" pairs, which must
+// appear in the order given by units, each at most once.
+func parseDurationComponents(s string, units []durationUnit) (time.Duration, error) {
+	var total time.Duration
+	next := 0
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+		if i < 0 {
+			return 0, fmt.Errorf("%q has no designator", s)
+		}
+		num, designator := s[:i], s[i]
+		s = s[i+1:]
+
+		j := next
+		for j < len(units) && units[j].designator != designator {
+			j++
+		}
+		if j == len(units) {
+			return 0, fmt.Errorf("unexpected %q", string(designator))
+		}
+		next = j + 1
+
+		v, err := durationValue(num, units[j])
+		if err != nil {
+			return 0, err
+		}
+		if total > math.MaxInt64-v {
+			return 0, fmt.Errorf("out of range")
+		}
+		total += v
 	}
-	return b
+	return total, nil
+}
+
+// durationValue converts one component to a duration. Only seconds may
+// have a fractional part; precision beyond nanoseconds is truncated.
+func durationValue(num string, u durationUnit) (time.Duration, error) {
+	whole, frac, hasFrac := strings.Cut(num, ".")
+	if whole == "" && frac == "" {
+		return 0, fmt.Errorf("missing number before %q", string(u.designator))
+	}
+	if hasFrac && u.unit != time.Second {
+		return 0, fmt.Errorf("fraction not allowed in %q component", string(u.designator))
+	}
+
+	var n int64
+	if whole != "" {
+		var err error
+		n, err = strconv.ParseInt(whole, 10, 64)
+		if err != nil || n > int64(math.MaxInt64/u.unit) {
+			return 0, fmt.Errorf("%s%s out of range", whole, string(u.designator))
+		}
+	}
+	v := time.Duration(n) * u.unit
+
+	if frac != "" {
+		if strings.Contains(frac, ".") {
+			return 0, fmt.Errorf("malformed number %q", num)
+		}
+		frac = (frac + "000000000")[:9]
+		ns, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed number %q", num)
+		}
+		if v > math.MaxInt64-time.Duration(ns) {
+			return 0, fmt.Errorf("%s%s out of range", num, string(u.designator))
+		}
+		v += time.Duration(ns)
+	}
+	return v, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// imageCodec derives a codec name from an image mimeType (e.g. "image/jpeg" -> "jpeg").
+func imageCodec(mimeTypes ...string) string {
+	for _, mt := range mimeTypes {
+		if sub, ok := strings.CutPrefix(mt, "image/"); ok {
+			return sub
+		}
+	}
+	return ""
 }
 
 func firstNonZero(a, b int) int {
@@ -0,0 +1,161 @@
+package parser
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"", 0},
+		{"PT0S", 0},
+		{"PT1H2M3.5S", time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{"P1DT2H", 26 * time.Hour},
+		{"P1M", 30 * 24 * time.Hour},
+		{"P1Y2M3DT4H5M6S", (365+60+3)*24*time.Hour + 4*time.Hour + 5*time.Minute + 6*time.Second},
+		{"PT0.000000001S", time.Nanosecond},
+		{"PT.5S", 500 * time.Millisecond},
+		{"PT90M", 90 * time.Minute},
+		{"-PT5S", -5 * time.Second},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if err != nil {
+			t.Errorf("parseDuration(%q): %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseDuration(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	invalid := []string{
+		"P", "PT", "1H", "PT1H30", "P1H", "PT1D", "PT1S2M", "PT1M1M",
+		"P0.5D", "PT1.2.3S", "PT.S", "P-1D", "garbage", "P99999999999DT1S",
+	}
+	for _, in := range invalid {
+		if _, err := parseDuration(in); err == nil {
+			t.Errorf("parseDuration(%q): expected error", in)
+		}
+	}
+}
+
+func parseTestMPD(t *testing.T, doc string) (*MPD, *url.URL) {
+	t.Helper()
+	var mpd MPD
+	if err := xml.Unmarshal([]byte(doc), &mpd); err != nil {
+		t.Fatal(err)
+	}
+	base, _ := url.Parse("https://example.com/stream.mpd")
+	return &mpd, base
+}
+
+func TestConvertMPDPeriodDurations(t *testing.T) {
+	mpd, base := parseTestMPD(t, `<MPD mediaPresentationDuration="P0DT1M">
+  <Period id="a" duration="PT30S">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="a_$Number$.m4s" duration="4" startNumber="1"/>
+      <Representation id="v1" bandwidth="1000"/>
+    </AdaptationSet>
+  </Period>
+  <Period id="b">
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="b_$Number$.m4s" duration="6" startNumber="1"/>
+      <Representation id="v2" bandwidth="1000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	manifest, err := NewDASHParser().convertMPD(mpd, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Duration != time.Minute {
+		t.Errorf("duration = %s, want 1m", manifest.Duration)
+	}
+	// 30s / 4s rounds up to 8; the second period runs 30s-60s: 5 segments
+	if got := len(manifest.Tracks[0].Segments); got != 8 {
+		t.Errorf("period a: %d segments, want 8", got)
+	}
+	if got := len(manifest.Tracks[1].Segments); got != 5 {
+		t.Errorf("period b: %d segments, want 5", got)
+	}
+}
+
+func TestConvertMPDErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"bad duration", `<MPD mediaPresentationDuration="P1H"/>`, "mediaPresentationDuration"},
+		{"bad time shift", `<MPD timeShiftBufferDepth="PT"/>`, "timeShiftBufferDepth"},
+		{"bad period start", `<MPD><Period start="soon"/></MPD>`, "period 0 start"},
+		{"unknown count", `<MPD><Period><AdaptationSet mimeType="video/mp4">
+  <SegmentTemplate media="$Number$.m4s" duration="4"/>
+  <Representation id="v1"/>
+</AdaptationSet></Period></MPD>`, "cannot determine segment count"},
+	}
+	for _, tt := range tests {
+		mpd, base := parseTestMPD(t, tt.doc)
+		_, err := NewDASHParser().convertMPD(mpd, base)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestConvertEventStreamsEpochTimes(t *testing.T) {
+	// presentationTime in 1/90000 s since 1970: seconds times 1e9 overflow int64
+	period := Period{EventStreams: []EventStream{{
+		SchemeIdUri: "urn:scte:scte35:2014:xml+bin",
+		Timescale:   90000,
+		Events:      []DASHEvent{{ID: "1", PresentationTime: 1_700_000_000*90000 + 45000, Duration: 30 * 90000}},
+	}}}
+	events := convertEventStreams(period, 0)
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	wantStart := 1_700_000_000*time.Second + 500*time.Millisecond
+	if events[0].Start != wantStart || events[0].Duration != 30*time.Second {
+		t.Errorf("start, duration = %s, %s; want %s, 30s", events[0].Start, events[0].Duration, wantStart)
+	}
+}
+
+func TestConvertMPDImageTiles(t *testing.T) {
+	mpd, base := parseTestMPD(t, `<MPD mediaPresentationDuration="PT20S">
+  <Period>
+    <AdaptationSet contentType="image">
+      <SegmentTemplate media="tile_$Number$.jpg" duration="10" startNumber="1"/>
+      <Representation id="thumbs" mimeType="image/jpeg" width="1280" height="720" bandwidth="5000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`)
+
+	manifest, err := NewDASHParser().convertMPD(mpd, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	track := manifest.Tracks[0]
+	if track.Type != models.TrackThumbnail || track.Codec != "jpeg" {
+		t.Errorf("type, codec = %s, %q; want thumbnail, jpeg", track.Type, track.Codec)
+	}
+	if len(track.Segments) != 2 {
+		t.Errorf("%d tiles, want 2", len(track.Segments))
+	}
+
+	if got := detectTrackType("image/png", ""); got != models.TrackThumbnail {
+		t.Errorf("detectTrackType(image/png) = %s", got)
+	}
+	if got := imageCodec("video/mp4", "image/webp"); got != "webp" {
+		t.Errorf("imageCodec = %q, want webp", got)
+	}
+}
@@ -1,10 +1,9 @@
 package parser
 
 import (
-	"bufio"
 	"context"
 	"fmt"
-	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"regexp"
@@ -12,21 +11,40 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/models"
 )
 
 // HLSParser parses HLS (m3u8) manifests.
 type HLSParser struct {
 	client *http.Client
+	retry  httpclient.RetryPolicy
+	log    *slog.Logger
 }
 
 // NewHLSParser creates a new HLS parser.
 func NewHLSParser() *HLSParser {
 	return &HLSParser{
-		client: &http.Client{Timeout: 30 * time.Second},
+		client: defaultClient(),
+		retry:  httpclient.DefaultRetryPolicy(),
 	}
 }
 
+// SetClient sets the HTTP client used to fetch manifests.
+func (p *HLSParser) SetClient(client *http.Client) {
+	p.client = client
+}
+
+// SetRetryPolicy sets how failed manifest fetches are retried.
+func (p *HLSParser) SetRetryPolicy(policy httpclient.RetryPolicy) {
+	p.retry = policy
+}
+
+// SetLogger sets the logger for fetch retries.
+func (p *HLSParser) SetLogger(log *slog.Logger) {
+	p.log = log
+}
+
 // CanParse checks if URL is an HLS manifest.
 func (p *HLSParser) CanParse(urlStr string) bool {
 	lower := strings.ToLower(urlStr)
@@ -35,6 +53,12 @@ func (p *HLSParser) CanParse(urlStr string) bool {
 
 // Parse parses an HLS manifest.
 func (p *HLSParser) Parse(ctx context.Context, urlStr string, headers map[string]string) (*models.Manifest, error) {
+	return p.parse(ctx, urlStr, headers, nil)
+}
+
+// parse fetches and parses a playlist. parentVars holds the master playlist
+// variables a media playlist may IMPORT.
+func (p *HLSParser) parse(ctx context.Context, urlStr string, headers map[string]string, parentVars map[string]string) (*models.Manifest, error) {
 	content, err := p.fetch(ctx, urlStr, headers)
 	if err != nil {
 		return nil, fmt.Errorf("fetch manifest: %w", err)
@@ -46,7 +70,7 @@ func (p *HLSParser) Parse(ctx context.Context, urlStr string, headers map[string
 	if strings.Contains(content, "#EXT-X-STREAM-INF") {
 		return p.parseMaster(ctx, content, baseURL, headers)
 	}
-	return p.parseMedia(content, baseURL)
+	return p.parseMedia(content, baseURL, parentVars)
 }
 
 // parseMaster parses a master playlist.
@@ -58,20 +82,61 @@ func (p *HLSParser) parseMaster(ctx context.Context, content string, baseURL *ur
 
 	lines := strings.Split(content, "\n")
 	var currentAttrs map[string]string
+	vars := make(hlsVariables)
 
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 
+		if strings.HasPrefix(line, "#EXT-X-DEFINE:") {
+			vars.define(parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-DEFINE:")), baseURL, nil)
+			continue
+		}
+		line = vars.substitute(line)
+
 		switch {
+		case strings.HasPrefix(line, "#EXT-X-SESSION-DATA:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-SESSION-DATA:"))
+			data := models.SessionData{
+				ID:       strings.Trim(attrs["DATA-ID"], "\""),
+				Value:    strings.Trim(attrs["VALUE"], "\""),
+				Language: strings.Trim(attrs["LANGUAGE"], "\""),
+			}
+			if uri, ok := attrs["URI"]; ok {
+				data.URI = resolveURL(baseURL, strings.Trim(uri, "\""))
+			}
+			manifest.SessionData = append(manifest.SessionData, data)
+
+		case strings.HasPrefix(line, "#EXT-X-SESSION-KEY:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-SESSION-KEY:"))
+			if attrs["METHOD"] == "AES-128" {
+				if uri, ok := attrs["URI"]; ok {
+					manifest.SessionKeys = append(manifest.SessionKeys, resolveURL(baseURL, strings.Trim(uri, "\"")))
+				}
+			}
+
 		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
 			currentAttrs = parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
 
+		case strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"):
+			// I-frame (trick-play) playlist, URI is an attribute; segments are lazy loaded
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-I-FRAME-STREAM-INF:"))
+			if uri, ok := attrs["URI"]; ok {
+				mediaURL := resolveURL(baseURL, strings.Trim(uri, "\""))
+				track := p.parseStreamTrack(attrs, mediaURL)
+				track.Type = models.TrackThumbnail
+				track.ID = fmt.Sprintf("iframe_%d_%d", track.Resolution.Height, track.Bandwidth)
+				track.MediaPlaylistURL = mediaURL
+				track.PlaylistVars = vars
+				manifest.Tracks = append(manifest.Tracks, track)
+			}
+
 		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
 			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
 			track, mediaURL := p.parseMediaTrack(attrs, baseURL)
 			// Only add tracks that have a URI - tracks without URI are muxed into video variants
 			if track != nil && mediaURL != "" {
 				track.MediaPlaylistURL = mediaURL
+				track.PlaylistVars = vars
 				manifest.Tracks = append(manifest.Tracks, track)
 			}
 
@@ -81,11 +146,15 @@ func (p *HLSParser) parseMaster(ctx context.Context, content string, baseURL *ur
 			track := p.parseStreamTrack(currentAttrs, mediaURL)
 
 			// Parse media playlist to get segments
-			mediaManifest, err := p.Parse(ctx, mediaURL, headers)
+			mediaManifest, err := p.parse(ctx, mediaURL, headers, vars)
 			if err == nil && len(mediaManifest.Tracks) > 0 {
 				track.Segments = mediaManifest.Tracks[0].Segments
 				track.InitSegment = mediaManifest.Tracks[0].InitSegment
 			}
+			// Variants share one timeline, take markers from the first
+			if err == nil && manifest.Events == nil {
+				manifest.Events = mediaManifest.Events
+			}
 
 			manifest.Tracks = append(manifest.Tracks, track)
 			currentAttrs = nil
@@ -96,7 +165,13 @@ func (p *HLSParser) parseMaster(ctx context.Context, content string, baseURL *ur
 }
 
 // parseMedia parses a media playlist.
-func (p *HLSParser) parseMedia(content string, baseURL *url.URL) (*models.Manifest, error) {
+func (p *HLSParser) parseMedia(content string, baseURL *url.URL, parentVars map[string]string) (*models.Manifest, error) {
+	return scanMediaPlaylist(content, baseURL, parentVars), nil
+}
+
+// scanMediaPlaylist parses a media playlist into a manifest with a single
+// track. parentVars holds the master playlist variables it may IMPORT.
+func scanMediaPlaylist(content string, baseURL *url.URL, parentVars map[string]string) *models.Manifest {
 	manifest := &models.Manifest{
 		URL:  baseURL.String(),
 		Type: models.ManifestHLS,
@@ -107,12 +182,25 @@ func (p *HLSParser) parseMedia(content string, baseURL *url.URL) (*models.Manife
 		Type: models.TrackVideo,
 	}
 
-	scanner := bufio.NewScanner(strings.NewReader(content))
+	lines := strings.Split(content, "\n")
 	var segmentDuration time.Duration
 	segmentIndex := 0
+	var byteRange, lastRange *models.ByteRange
+	events := newHLSEventParser()
+	vars := make(hlsVariables)
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		if strings.HasPrefix(line, "#EXT-X-DEFINE:") {
+			vars.define(parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-DEFINE:")), baseURL, parentVars)
+			continue
+		}
+		line = vars.substitute(line)
+
+		if events.handle(line, manifest.Duration) {
+			continue
+		}
 
 		switch {
 		case strings.HasPrefix(line, "#EXTINF:"):
@@ -132,6 +220,9 @@ func (p *HLSParser) parseMedia(content string, baseURL *url.URL) (*models.Manife
 				track.EncryptionIV = parseHexBytes(iv)
 			}
 
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			byteRange = parseHLSByteRange(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"), lastRange)
+
 		case strings.HasPrefix(line, "#EXT-X-MAP:"):
 			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
 			if uri, ok := attrs["URI"]; ok {
@@ -146,10 +237,12 @@ func (p *HLSParser) parseMedia(content string, baseURL *url.URL) (*models.Manife
 
 		case !strings.HasPrefix(line, "#") && line != "":
 			segment := &models.Segment{
-				Index:    segmentIndex,
-				URL:      resolveURL(baseURL, line),
-				Duration: segmentDuration,
+				Index:     segmentIndex,
+				URL:       resolveURL(baseURL, line),
+				Duration:  segmentDuration,
+				ByteRange: byteRange,
 			}
+			lastRange, byteRange = byteRange, nil
 			track.Segments = append(track.Segments, segment)
 			manifest.Duration += segmentDuration
 			segmentIndex++
@@ -157,7 +250,8 @@ func (p *HLSParser) parseMedia(content string, baseURL *url.URL) (*models.Manife
 	}
 
 	manifest.Tracks = append(manifest.Tracks, track)
-	return manifest, nil
+	manifest.Events = events.finish(manifest.Duration)
+	return manifest
 }
 
 // parseStreamTrack creates a track from STREAM-INF attributes.
@@ -229,27 +323,25 @@ func (p *HLSParser) parseMediaTrack(attrs map[string]string, baseURL *url.URL) (
 
 // fetch downloads content from URL.
 func (p *HLSParser) fetch(ctx context.Context, urlStr string, headers map[string]string) (string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
-	if err != nil {
-		return "", err
-	}
-
-	for k, v := range headers {
-		req.Header.Set(k, v)
-	}
+	return fetchText(ctx, p.client, p.retry, p.log, urlStr, headers)
+}
 
-	resp, err := p.client.Do(req)
-	if err != nil {
-		return "", err
+// parseHLSByteRange parses an EXT-X-BYTERANGE value ("length[@offset]").
+// Without an offset the range starts right after the previous one.
+func parseHLSByteRange(s string, prev *models.ByteRange) *models.ByteRange {
+	lengthStr, offsetStr, hasOffset := strings.Cut(strings.TrimSpace(s), "@")
+	length, err := strconv.ParseInt(lengthStr, 10, 64)
+	if err != nil || length <= 0 {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP %d", resp.StatusCode)
+	var start int64
+	if hasOffset {
+		start, _ = strconv.ParseInt(offsetStr, 10, 64)
+	} else if prev != nil {
+		start = prev.End + 1
 	}
-
-	body, err := io.ReadAll(resp.Body)
-	return string(body), err
+	return &models.ByteRange{Start: start, End: start + length - 1}
 }
 
 // parseHLSAttributes parses HLS attribute string.
@@ -267,48 +359,195 @@ func parseHLSAttributes(s string) map[string]string {
 
 // ParseMediaPlaylist parses an HLS media playlist and returns segments and init segment.
 // This is exported for use by the engine for lazy loading audio/subtitle tracks.
-func ParseMediaPlaylist(content string, baseURLStr string) ([]*models.Segment, *models.Segment) {
-	baseURL, _ := url.Parse(baseURLStr)
-	var segments []*models.Segment
-	var initSegment *models.Segment
+// parentVars holds the master playlist variables the playlist may IMPORT.
+func ParseMediaPlaylist(content string, baseURLStr string, parentVars map[string]string) ([]*models.Segment, *models.Segment) {
+	baseURL, err := url.Parse(baseURLStr)
+	if err != nil {
+		baseURL = &url.URL{} // Only absolute segment URLs resolve
+	}
+	track := scanMediaPlaylist(content, baseURL, parentVars).Tracks[0]
+	return track.Segments, track.InitSegment
+}
 
-	lines := strings.Split(content, "\n")
-	var segmentDuration time.Duration
-	segmentIndex := 0
+// hlsEventParser collects ad markers and date ranges from a media playlist.
+type hlsEventParser struct {
+	events  []*models.Event
+	byID    map[string]*models.Event
+	dates   map[*models.Event]time.Time // START-DATE of date ranges, resolved in finish
+	openCue *models.Event               // EXT-X-CUE-OUT waiting for its CUE-IN
+	scte35  string                      // payload of a preceding EXT-OATCLS-SCTE35
 
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	// Wall clock time at stream position 0, from EXT-X-PROGRAM-DATE-TIME
+	pdtBase time.Time
+}
 
-		switch {
-		case strings.HasPrefix(line, "#EXTINF:"):
-			durStr := strings.TrimPrefix(line, "#EXTINF:")
-			durStr = strings.Split(durStr, ",")[0]
-			if dur, err := strconv.ParseFloat(durStr, 64); err == nil {
-				segmentDuration = time.Duration(dur * float64(time.Second))
+func newHLSEventParser() *hlsEventParser {
+	return &hlsEventParser{
+		byID:  make(map[string]*models.Event),
+		dates: make(map[*models.Event]time.Time),
+	}
+}
+
+// handle processes an event-related tag found at stream position pos.
+// It returns false if the line is not an event tag.
+func (h *hlsEventParser) handle(line string, pos time.Duration) bool {
+	switch {
+	case strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"))
+		if err == nil && h.pdtBase.IsZero() {
+			h.pdtBase = t.Add(-pos)
+		}
+
+	case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+		h.handleDateRange(parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-DATERANGE:")), pos)
+
+	case strings.HasPrefix(line, "#EXT-X-CUE-OUT-CONT"):
+		// Progress marker inside an ad break, nothing to record
+
+	case strings.HasPrefix(line, "#EXT-X-CUE-OUT"):
+		ev := &models.Event{
+			ID:     fmt.Sprintf("cue-%d", len(h.events)),
+			Type:   models.EventAdBreak,
+			Class:  "CUE-OUT",
+			Start:  pos,
+			SCTE35: h.scte35,
+		}
+		h.scte35 = ""
+		if _, val, ok := strings.Cut(line, ":"); ok {
+			val = strings.TrimPrefix(val, "DURATION=")
+			if secs, err := strconv.ParseFloat(strings.Trim(val, "\""), 64); err == nil {
+				ev.Duration = time.Duration(secs * float64(time.Second))
 			}
+		}
+		h.events = append(h.events, ev)
+		h.openCue = ev
 
-		case strings.HasPrefix(line, "#EXT-X-MAP:"):
-			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
-			if uri, ok := attrs["URI"]; ok {
-				initSegment = &models.Segment{
-					Index: -1,
-					URL:   resolveURL(baseURL, strings.Trim(uri, "\"")),
-				}
-				if br, ok := attrs["BYTERANGE"]; ok {
-					initSegment.ByteRange = parseByteRange(br)
-				}
+	case strings.HasPrefix(line, "#EXT-X-CUE-IN"):
+		if h.openCue != nil {
+			if pos > h.openCue.Start {
+				h.openCue.Duration = pos - h.openCue.Start
 			}
+			h.openCue = nil
+		}
 
-		case !strings.HasPrefix(line, "#") && line != "":
-			segment := &models.Segment{
-				Index:    segmentIndex,
-				URL:      resolveURL(baseURL, line),
-				Duration: segmentDuration,
+	case strings.HasPrefix(line, "#EXT-OATCLS-SCTE35:"):
+		h.scte35 = strings.TrimPrefix(line, "#EXT-OATCLS-SCTE35:")
+
+	default:
+		return false
+	}
+	return true
+}
+
+// handleDateRange records an EXT-X-DATERANGE tag.
+func (h *hlsEventParser) handleDateRange(attrs map[string]string, pos time.Duration) {
+	for k, v := range attrs {
+		attrs[k] = strings.Trim(v, "\"")
+	}
+	id := attrs["ID"]
+
+	// A later tag with the same ID closes an SCTE-35 OUT with its IN
+	if prev, ok := h.byID[id]; ok && id != "" {
+		if in, ok := attrs["SCTE35-IN"]; ok {
+			if start, ok := h.dates[prev]; ok {
+				if end, err := time.Parse(time.RFC3339Nano, attrs["START-DATE"]); err == nil && end.After(start) {
+					prev.Duration = end.Sub(start)
+				}
 			}
-			segments = append(segments, segment)
-			segmentIndex++
+			if prev.Duration == 0 && pos > prev.Start {
+				prev.Duration = pos - prev.Start
+			}
+			prev.Attributes["SCTE35-IN"] = in
+		}
+		return
+	}
+
+	ev := &models.Event{
+		ID:         id,
+		Class:      attrs["CLASS"],
+		Start:      pos,
+		Attributes: attrs,
+	}
+	for _, key := range []string{"SCTE35-OUT", "SCTE35-CMD"} {
+		if v, ok := attrs[key]; ok {
+			ev.Type = models.EventAdBreak
+			ev.SCTE35 = v
+		}
+	}
+
+	durStr := attrs["DURATION"]
+	if durStr == "" {
+		durStr = attrs["PLANNED-DURATION"]
+	}
+	if secs, err := strconv.ParseFloat(durStr, 64); err == nil {
+		ev.Duration = time.Duration(secs * float64(time.Second))
+	}
+
+	if start, err := time.Parse(time.RFC3339Nano, attrs["START-DATE"]); err == nil {
+		h.dates[ev] = start
+		if end, err := time.Parse(time.RFC3339Nano, attrs["END-DATE"]); err == nil && ev.Duration == 0 && end.After(start) {
+			ev.Duration = end.Sub(start)
+		}
+	}
+
+	h.events = append(h.events, ev)
+	if id != "" {
+		h.byID[id] = ev
+	}
+}
+
+// finish resolves date ranges against the program date time and closes any
+// ad break still open at the end of the playlist.
+func (h *hlsEventParser) finish(end time.Duration) []*models.Event {
+	if !h.pdtBase.IsZero() {
+		for ev, start := range h.dates {
+			ev.Start = max(start.Sub(h.pdtBase), 0)
+		}
+	}
+	if h.openCue != nil && h.openCue.Duration == 0 && end > h.openCue.Start {
+		h.openCue.Duration = end - h.openCue.Start
+	}
+	return h.events
+}
+
+// hlsVariables holds the EXT-X-DEFINE variables of a playlist.
+type hlsVariables map[string]string
+
+// hlsVariableRe matches a {$name} variable reference.
+var hlsVariableRe = regexp.MustCompile(`\{\$([A-Za-z0-9_-]+)\}`)
+
+// define records an EXT-X-DEFINE tag. A variable is either declared with
+// NAME/VALUE, imported from the master playlist (IMPORT) or taken from the
+// playlist URL's query string (QUERYPARAM).
+func (v hlsVariables) define(attrs map[string]string, playlistURL *url.URL, parentVars map[string]string) {
+	switch {
+	case attrs["NAME"] != "":
+		v[strings.Trim(attrs["NAME"], "\"")] = strings.Trim(attrs["VALUE"], "\"")
+
+	case attrs["IMPORT"] != "":
+		name := strings.Trim(attrs["IMPORT"], "\"")
+		if val, ok := parentVars[name]; ok {
+			v[name] = val
+		}
+
+	case attrs["QUERYPARAM"] != "":
+		name := strings.Trim(attrs["QUERYPARAM"], "\"")
+		if playlistURL != nil && playlistURL.Query().Has(name) {
+			v[name] = playlistURL.Query().Get(name)
 		}
 	}
+}
 
-	return segments, initSegment
+// substitute replaces {$name} references with their values.
+// Undefined references are left unchanged.
+func (v hlsVariables) substitute(s string) string {
+	if len(v) == 0 || !strings.Contains(s, "{$") {
+		return s
+	}
+	return hlsVariableRe.ReplaceAllStringFunc(s, func(ref string) string {
+		if val, ok := v[ref[2:len(ref)-1]]; ok {
+			return val
+		}
+		return ref
+	})
 }
@@ -0,0 +1,192 @@
+package parser
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mohaanymo/veld/internal/models"
+)
+
+func TestParseMediaEvents(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00Z
+#EXTINF:6,
+seg0.ts
+#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-01-01T00:00:06Z",PLANNED-DURATION=12,SCTE35-OUT=0xFC30
+#EXTINF:6,
+seg1.ts
+#EXTINF:6,
+seg2.ts
+#EXT-X-DATERANGE:ID="ad1",START-DATE="2024-01-01T00:00:18Z",SCTE35-IN=0xFC31
+#EXTINF:6,
+seg3.ts
+#EXT-X-CUE-OUT:DURATION=6
+#EXTINF:6,
+seg4.ts
+#EXT-X-CUE-IN
+#EXTINF:6,
+seg5.ts
+`
+	base, _ := url.Parse("https://example.com/media.m3u8")
+	manifest, err := NewHLSParser().parseMedia(playlist, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Events) != 2 {
+		t.Fatalf("got %d events, want 2", len(manifest.Events))
+	}
+
+	want := []struct {
+		start, dur time.Duration
+	}{
+		{6 * time.Second, 12 * time.Second},
+		{24 * time.Second, 6 * time.Second},
+	}
+	for i, w := range want {
+		ev := manifest.Events[i]
+		if !ev.IsAdBreak() {
+			t.Errorf("event %d: not an ad break", i)
+		}
+		if ev.Start != w.start || ev.Duration != w.dur {
+			t.Errorf("event %d: got %s+%s, want %s+%s", i, ev.Start, ev.Duration, w.start, w.dur)
+		}
+	}
+	if manifest.Events[0].SCTE35 != "0xFC30" {
+		t.Errorf("SCTE35 = %q", manifest.Events[0].SCTE35)
+	}
+}
+
+func TestParseMediaPlaylistVariables(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-DEFINE:NAME="path",VALUE="media/v1"
+#EXT-X-DEFINE:IMPORT="cdn"
+#EXT-X-DEFINE:QUERYPARAM="token"
+#EXTINF:6,
+{$cdn}/{$path}/seg0.ts?token={$token}
+#EXTINF:6,
+{$path}/seg1.ts?missing={$nope}
+`
+	segments, _ := ParseMediaPlaylist(playlist,
+		"https://example.com/live/index.m3u8?token=abc",
+		map[string]string{"cdn": "https://cdn.example.com"})
+
+	want := []string{
+		"https://cdn.example.com/media/v1/seg0.ts?token=abc",
+		"https://example.com/live/media/v1/seg1.ts?missing={$nope}",
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		if segments[i].URL != w {
+			t.Errorf("segment %d: got %q, want %q", i, segments[i].URL, w)
+		}
+	}
+}
+
+func TestParseHLSByteRange(t *testing.T) {
+	prev := &models.ByteRange{Start: 0, End: 999}
+	tests := []struct {
+		in   string
+		prev *models.ByteRange
+		want *models.ByteRange
+	}{
+		{"500@1000", nil, &models.ByteRange{Start: 1000, End: 1499}},
+		{"500", prev, &models.ByteRange{Start: 1000, End: 1499}}, // continues the previous range
+		{"500", nil, &models.ByteRange{Start: 0, End: 499}},
+		{"0@10", nil, nil},
+		{"abc", nil, nil},
+	}
+	for _, tt := range tests {
+		got := parseHLSByteRange(tt.in, tt.prev)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("parseHLSByteRange(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseMediaByteRanges(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-MAP:URI="main.mp4",BYTERANGE="720@0"
+#EXTINF:6,
+#EXT-X-BYTERANGE:1000@720
+main.mp4
+#EXTINF:6,
+#EXT-X-BYTERANGE:2000
+main.mp4
+#EXTINF:6,
+other.mp4
+`
+	segments, init := ParseMediaPlaylist(playlist, "https://example.com/v/index.m3u8", nil)
+	if init == nil || init.ByteRange == nil || *init.ByteRange != (models.ByteRange{Start: 0, End: 719}) {
+		t.Errorf("init segment = %+v", init)
+	}
+	want := []*models.ByteRange{{Start: 720, End: 1719}, {Start: 1720, End: 3719}, nil}
+	if len(segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		got := segments[i].ByteRange
+		if (got == nil) != (w == nil) || (got != nil && *got != *w) {
+			t.Errorf("segment %d: range %v, want %v", i, got, w)
+		}
+	}
+}
+
+func TestParseMasterIFrameStreams(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=86000,RESOLUTION=640x360,CODECS="avc1.4d001f",URI="iframes/360.m3u8"
+`
+	base, _ := url.Parse("https://example.com/master.m3u8")
+	manifest, err := NewHLSParser().parseMaster(context.Background(), playlist, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Tracks) != 1 {
+		t.Fatalf("got %d tracks, want 1", len(manifest.Tracks))
+	}
+	track := manifest.Tracks[0]
+	if track.Type != models.TrackThumbnail {
+		t.Errorf("type = %s, want thumbnail", track.Type)
+	}
+	if track.ID != "iframe_360_86000" || track.MediaPlaylistURL != "https://example.com/iframes/360.m3u8" {
+		t.Errorf("ID, URL = %q, %q", track.ID, track.MediaPlaylistURL)
+	}
+}
+
+func TestParseMasterSessionData(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-DEFINE:NAME="keys",VALUE="https://keys.example.com"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.title",VALUE="Launch",LANGUAGE="en"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.lyrics",URI="lyrics.json"
+#EXT-X-SESSION-KEY:METHOD=AES-128,URI="{$keys}/k1"
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://k2",KEYFORMAT="com.apple.streamingkeydelivery"
+`
+	base, _ := url.Parse("https://example.com/hls/master.m3u8")
+	manifest, err := NewHLSParser().parseMaster(context.Background(), playlist, base, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []models.SessionData{
+		{ID: "com.example.title", Value: "Launch", Language: "en"},
+		{ID: "com.example.lyrics", URI: "https://example.com/hls/lyrics.json"},
+	}
+	if len(manifest.SessionData) != len(want) {
+		t.Fatalf("got %d session data entries, want %d", len(manifest.SessionData), len(want))
+	}
+	for i, w := range want {
+		if manifest.SessionData[i] != w {
+			t.Errorf("session data %d = %+v, want %+v", i, manifest.SessionData[i], w)
+		}
+	}
+
+	// Only AES-128 keys can be fetched ahead
+	if len(manifest.SessionKeys) != 1 || manifest.SessionKeys[0] != "https://keys.example.com/k1" {
+		t.Errorf("session keys = %q, want [https://keys.example.com/k1]", manifest.SessionKeys)
+	}
+}
@@ -4,10 +4,15 @@ package parser
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/models"
 )
 
@@ -20,6 +25,7 @@ type Parser interface {
 // Registry manages available parsers.
 type Registry struct {
 	parsers []Parser
+	log     *slog.Logger
 }
 
 // NewRegistry creates a new parser registry with default parsers.
@@ -29,6 +35,37 @@ func NewRegistry() *Registry {
 			NewHLSParser(),
 			NewDASHParser(),
 		},
+		log: slog.New(slog.DiscardHandler),
+	}
+}
+
+// SetClient sets the HTTP client used by every parser that fetches over
+// HTTP.
+func (r *Registry) SetClient(client *http.Client) {
+	for _, p := range r.parsers {
+		if cp, ok := p.(interface{ SetClient(*http.Client) }); ok {
+			cp.SetClient(client)
+		}
+	}
+}
+
+// SetRetryPolicy sets how failed manifest fetches are retried by every
+// parser that supports retries.
+func (r *Registry) SetRetryPolicy(policy httpclient.RetryPolicy) {
+	for _, p := range r.parsers {
+		if rp, ok := p.(interface{ SetRetryPolicy(httpclient.RetryPolicy) }); ok {
+			rp.SetRetryPolicy(policy)
+		}
+	}
+}
+
+// SetLogger sets the logger of the registry and every parser that logs.
+func (r *Registry) SetLogger(log *slog.Logger) {
+	r.log = log
+	for _, p := range r.parsers {
+		if lp, ok := p.(interface{ SetLogger(*slog.Logger) }); ok {
+			lp.SetLogger(log)
+		}
 	}
 }
 
@@ -36,7 +73,12 @@ func NewRegistry() *Registry {
 func (r *Registry) Parse(ctx context.Context, urlStr string, headers map[string]string) (*models.Manifest, error) {
 	for _, p := range r.parsers {
 		if p.CanParse(urlStr) {
-			return p.Parse(ctx, urlStr, headers)
+			manifest, err := p.Parse(ctx, urlStr, headers)
+			if err == nil {
+				r.log.DebugContext(ctx, "parsed manifest", "url", httpclient.RedactURL(urlStr),
+					"type", manifest.Type.String(), "tracks", len(manifest.Tracks), "duration", manifest.Duration)
+			}
+			return manifest, err
 		}
 	}
 	return nil, fmt.Errorf("no parser found for URL: %s", urlStr)
@@ -44,6 +86,47 @@ func (r *Registry) Parse(ctx context.Context, urlStr string, headers map[string]
 
 // Common helper functions used by parsers
 
+// defaultClient is used by parsers until SetClient gives them the
+// downloader's client.
+func defaultClient() *http.Client {
+	cfg := httpclient.DefaultConfig()
+	cfg.Timeout = 30 * time.Second
+	return httpclient.New(cfg)
+}
+
+// fetchText downloads a manifest, retrying transient failures. Retries are
+// logged to log, if set.
+func fetchText(ctx context.Context, client *http.Client, retry httpclient.RetryPolicy, log *slog.Logger, urlStr string, headers map[string]string) (string, error) {
+	if log != nil {
+		retry = retry.Logged(log, "url", httpclient.RedactURL(urlStr))
+	}
+	var body []byte
+	err := retry.Do(ctx, func(int) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, urlStr, nil)
+		if err != nil {
+			return httpclient.Permanent(err)
+		}
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if err := httpclient.CheckResponse(resp); err != nil {
+			return err
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		return err
+	})
+	return string(body), err
+}
+
 // resolveURL resolves a relative URL against a base URL.
 func resolveURL(base *url.URL, relative string) string {
 	if strings.HasPrefix(relative, "http://") || strings.HasPrefix(relative, "https://") {
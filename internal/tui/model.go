@@ -57,6 +57,7 @@ type Model struct {
 	startTime     time.Time
 	speed         float64
 	eta           time.Duration
+	concurrency   int
 	err           error
 }
 
@@ -192,7 +193,9 @@ func (m *Model) renderTrack(tp *trackProgress, w int) string {
 	var b strings.Builder
 
 	// Badge
-	if tp.track.IsSubtitle() {
+	if tp.track.IsThumbnail() {
+		b.WriteString(thumbnailBadge.Render("THUMB"))
+	} else if tp.track.IsSubtitle() {
 		b.WriteString(subtitleBadge.Render("SUB"))
 	} else if tp.track.IsAudio() {
 		b.WriteString(audioBadge.Render("AUDIO"))
@@ -265,6 +268,7 @@ func (m *Model) renderStats() string {
 		{"Downloaded", formatBytes(m.downloaded)},
 		{"Elapsed", formatDuration(time.Since(m.startTime))},
 		{"ETA", formatDuration(m.eta)},
+		{"Threads", m.threadsLabel()},
 	}
 
 	var parts []string
@@ -276,6 +280,19 @@ func (m *Model) renderStats() string {
 	return strings.Join(parts, "  ")
 }
 
+// threadsLabel shows the current concurrency, and the maximum when it is
+// tuned adaptively.
+func (m *Model) threadsLabel() string {
+	n := m.concurrency
+	if n == 0 {
+		n = m.cfg.Threads
+	}
+	if m.cfg.AdaptiveThreads {
+		return fmt.Sprintf("%d/%d auto", n, m.cfg.Threads)
+	}
+	return fmt.Sprintf("%d", n)
+}
+
 func (m *Model) renderStatus() string {
 	switch m.state {
 	case stateStarting:
@@ -300,6 +317,9 @@ func (m *Model) renderHelp() string {
 }
 
 func (m *Model) handleProgress(p engine.ProgressUpdate) {
+	if p.Concurrency > 0 {
+		m.concurrency = p.Concurrency
+	}
 	if tp, ok := m.tracks[p.TrackID]; ok {
 		if p.Completed {
 			tp.doneSegments++
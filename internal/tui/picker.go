@@ -21,6 +21,7 @@ type TrackPicker struct {
 	videos       []*models.Track
 	audios       []*models.Track
 	subtitles    []*models.Track
+	thumbnails   []*models.Track
 	selected     map[string]bool
 	cursor       int
 	scrollOffset int
@@ -44,6 +45,8 @@ func NewTrackPicker(tracks []*models.Track) *TrackPicker {
 	// Categorize tracks
 	for _, t := range tracks {
 		switch {
+		case t.IsThumbnail():
+			tp.thumbnails = append(tp.thumbnails, t)
 		case t.IsSubtitle():
 			tp.subtitles = append(tp.subtitles, t)
 		case t.IsAudio():
@@ -100,7 +103,7 @@ func (tp *TrackPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 		case "down", "j":
-			total := len(tp.videos) + len(tp.audios) + len(tp.subtitles)
+			total := len(tp.videos) + len(tp.audios) + len(tp.subtitles) + len(tp.thumbnails)
 			if tp.cursor < total-1 {
 				tp.cursor++
 				tp.adjustScroll()
@@ -127,6 +130,11 @@ func (tp *TrackPicker) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				tp.selected[t.ID] = true
 			}
 
+		case "t":
+			for _, t := range tp.thumbnails {
+				tp.selected[t.ID] = true
+			}
+
 		case "n":
 			for k := range tp.selected {
 				delete(tp.selected, k)
@@ -153,6 +161,10 @@ func (tp *TrackPicker) getTrackAtCursor() *models.Track {
 	if subIdx < len(tp.subtitles) {
 		return tp.subtitles[subIdx]
 	}
+	thumbIdx := subIdx - len(tp.subtitles)
+	if thumbIdx < len(tp.thumbnails) {
+		return tp.thumbnails[thumbIdx]
+	}
 	return nil
 }
 
@@ -197,6 +209,10 @@ func (tp *TrackPicker) View() string {
 		allTracks = append(allTracks, trackItem{s, "SUB", "Subtitle Tracks", globalIdx})
 		globalIdx++
 	}
+	for _, t := range tp.thumbnails {
+		allTracks = append(allTracks, trackItem{t, "THUMB", "Thumbnail Tracks", globalIdx})
+		globalIdx++
+	}
 
 	total := len(allTracks)
 
@@ -252,6 +268,7 @@ func (tp *TrackPicker) View() string {
 		keyHelpStyle.Render("v") + " all video  " +
 			keyHelpStyle.Render("a") + " all audio  " +
 			keyHelpStyle.Render("s") + " all subs  " +
+			keyHelpStyle.Render("t") + " all thumbs  " +
 			keyHelpStyle.Render("n") + " none",
 	))
 
@@ -280,6 +297,8 @@ func (tp *TrackPicker) renderTrackRow(t *models.Track, cursor, selected bool, ba
 		b.WriteString(audioBadge.Render("AUDIO"))
 	case "SUB":
 		b.WriteString(subtitleBadge.Render("SUB"))
+	case "THUMB":
+		b.WriteString(thumbnailBadge.Render("THUMB"))
 	}
 	b.WriteString(" ")
 
@@ -98,6 +98,12 @@ var (
 			Padding(0, 1).
 			Bold(true)
 
+	thumbnailBadge = lipgloss.NewStyle().
+			Foreground(colorBg).
+			Background(colorWarning).
+			Padding(0, 1).
+			Bold(true)
+
 	statLabelStyle = lipgloss.NewStyle().
 			Foreground(colorSubtle)
 
@@ -6,6 +6,9 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/mohaanymo/veld/internal/engine"
+	"github.com/mohaanymo/veld/internal/httpclient"
 )
 
 // TaskState represents the current state of a download task.
@@ -58,9 +61,10 @@ type Task struct {
 	SelectedTracks []*Track
 
 	// Internal
-	downloader *Downloader
-	cancel     context.CancelFunc
-	mu         sync.RWMutex
+	bandwidthWeight float64 // from SetTaskBandwidthWeight, 0 = from Options
+	downloader      *Downloader
+	cancel          context.CancelFunc
+	mu              sync.RWMutex
 }
 
 // TaskProgress holds progress information for a task.
@@ -106,6 +110,11 @@ type Manager struct {
 	// Default options applied to all tasks
 	defaultOptions []Option
 
+	// Bandwidth shared by all running tasks
+	budget *httpclient.Budget
+	// Host limits shared by all running tasks
+	hosts *httpclient.HostLimiter
+
 	mu sync.RWMutex
 }
 
@@ -139,6 +148,25 @@ func WithDefaultOptions(opts ...Option) ManagerOption {
 	}
 }
 
+// WithGlobalMaxBandwidth caps the combined download speed of all running
+// tasks in bytes per second (0 = unlimited). Tasks split it by their
+// WithBandwidthWeight; WithMaxBandwidth still caps each task on its own.
+// Use SetGlobalMaxBandwidth to change it while running.
+func WithGlobalMaxBandwidth(bytesPerSec int64) ManagerOption {
+	return func(m *Manager) {
+		m.budget.SetLimit(bytesPerSec)
+	}
+}
+
+// WithGlobalBandwidthSchedule overrides the global limit during daily time
+// windows, e.g. unlimited from 00:00 to 07:00. Outside the windows
+// WithGlobalMaxBandwidth applies.
+func WithGlobalBandwidthSchedule(windows ...BandwidthWindow) ManagerOption {
+	return func(m *Manager) {
+		m.budget.SetSchedule(windows)
+	}
+}
+
 // WithOnStateChange sets a callback for task state changes.
 func WithOnStateChange(fn func(task *Task)) ManagerOption {
 	return func(m *Manager) {
@@ -174,6 +202,8 @@ func NewManager(opts ...ManagerOption) *Manager {
 	m := &Manager{
 		maxConcurrent: 3,
 		queue:         make(chan *Task, 1000),
+		budget:        httpclient.NewBudget(0),
+		hosts:         httpclient.NewHostLimiter(),
 		ctx:           ctx,
 		cancel:        cancel,
 	}
@@ -207,6 +237,7 @@ func (m *Manager) Stop() {
 	close(m.queue)
 	m.cancel()
 	m.wg.Wait()
+	m.budget.Stop()
 }
 
 // worker processes tasks from the queue.
@@ -263,6 +294,52 @@ func (m *Manager) AddTask(id, url, filename string, opts ...Option) (*Task, erro
 	return task, nil
 }
 
+// SetGlobalMaxBandwidth changes the combined speed limit of all tasks in
+// bytes per second (0 = unlimited) outside scheduled windows. It takes
+// effect immediately, also for tasks already downloading.
+func (m *Manager) SetGlobalMaxBandwidth(bytesPerSec int64) {
+	m.budget.SetLimit(bytesPerSec)
+}
+
+// GlobalMaxBandwidth returns the combined speed limit outside scheduled
+// windows in bytes per second, 0 = unlimited.
+func (m *Manager) GlobalMaxBandwidth() int64 {
+	return m.budget.Limit()
+}
+
+// CurrentBandwidthLimit returns the combined speed limit in effect now,
+// following the schedule, 0 = unlimited.
+func (m *Manager) CurrentBandwidthLimit() int64 {
+	return m.budget.Current()
+}
+
+// SetBandwidthSchedule replaces the global bandwidth schedule; nil removes
+// it. Running tasks follow the new limits immediately.
+func (m *Manager) SetBandwidthSchedule(windows []BandwidthWindow) {
+	m.budget.SetSchedule(windows)
+}
+
+// SetTaskBandwidthWeight changes a task's weight in the global bandwidth
+// limit, e.g. 2 for twice the share of a task with weight 1. Applies to
+// running tasks and to pending ones when they start.
+func (m *Manager) SetTaskBandwidthWeight(id string, weight float64) error {
+	task := m.GetTask(id)
+	if task == nil {
+		return fmt.Errorf("task %q not found", id)
+	}
+
+	task.mu.Lock()
+	defer task.mu.Unlock()
+	if weight <= 0 {
+		weight = 1
+	}
+	task.bandwidthWeight = weight
+	if task.downloader != nil {
+		task.downloader.eng.SetBandwidthWeight(weight)
+	}
+	return nil
+}
+
 // GetTask returns a task by ID.
 func (m *Manager) GetTask(id string) *Task {
 	if t, ok := m.tasks.Load(id); ok {
@@ -410,17 +487,25 @@ func (m *Manager) processTask(task *Task) {
 	m.notifyStateChange(task)
 
 	// Create downloader with task options
+	task.mu.Lock()
 	opts := append([]Option{
 		WithURL(task.URL),
 		WithFileName(task.FileName),
 	}, task.Options...)
+	if task.bandwidthWeight > 0 {
+		opts = append(opts, WithBandwidthWeight(task.bandwidthWeight))
+	}
+	opts = append(opts, withTaskLogger(task.ID))
 
-	d, err := New(opts...)
+	d, err := newDownloader(engine.Shared{Budget: m.budget, Hosts: m.hosts}, opts...)
+	if err == nil {
+		task.downloader = d
+	}
+	task.mu.Unlock()
 	if err != nil {
 		m.failTask(task, fmt.Errorf("create downloader: %w", err))
 		return
 	}
-	task.downloader = d
 	defer d.Close()
 
 	// Parse manifest
@@ -440,6 +525,12 @@ func (m *Manager) processTask(task *Task) {
 		return
 	}
 
+	// Load lazy segments and apply time range so totals are accurate
+	if err := d.eng.PrepareTracks(ctx, d.manifest); err != nil {
+		m.failTask(task, fmt.Errorf("prepare tracks: %w", err))
+		return
+	}
+
 	task.mu.Lock()
 	task.SelectedTracks = d.SelectedTracks()
 	task.State = TaskDownloading
@@ -1,6 +1,10 @@
 package veld
 
 import (
+	"time"
+
+	"github.com/mohaanymo/veld/internal/engine"
+	"github.com/mohaanymo/veld/internal/httpclient"
 	"github.com/mohaanymo/veld/internal/models"
 )
 
@@ -8,9 +12,10 @@ import (
 type TrackType int
 
 const (
-	TrackVideo    TrackType = TrackType(models.TrackVideo)
-	TrackAudio    TrackType = TrackType(models.TrackAudio)
-	TrackSubtitle TrackType = TrackType(models.TrackSubtitle)
+	TrackVideo     TrackType = TrackType(models.TrackVideo)
+	TrackAudio     TrackType = TrackType(models.TrackAudio)
+	TrackSubtitle  TrackType = TrackType(models.TrackSubtitle)
+	TrackThumbnail TrackType = TrackType(models.TrackThumbnail)
 )
 
 func (t TrackType) String() string {
@@ -21,12 +26,14 @@ func (t TrackType) String() string {
 		return "audio"
 	case TrackSubtitle:
 		return "subtitle"
+	case TrackThumbnail:
+		return "thumbnail"
 	default:
 		return "unknown"
 	}
 }
 
-// Track represents a media track (video, audio, or subtitle).
+// Track represents a media track (video, audio, subtitle or thumbnail).
 type Track struct {
 	internal *models.Track
 }
@@ -36,7 +43,7 @@ func (t *Track) ID() string {
 	return t.internal.ID
 }
 
-// Type returns the track type (video, audio, or subtitle).
+// Type returns the track type (video, audio, subtitle or thumbnail).
 func (t *Track) Type() TrackType {
 	return TrackType(t.internal.Type)
 }
@@ -96,6 +103,11 @@ func (t *Track) IsSubtitle() bool {
 	return t.internal.IsSubtitle()
 }
 
+// IsThumbnail returns true if this is an I-frame or image thumbnail track.
+func (t *Track) IsThumbnail() bool {
+	return t.internal.IsThumbnail()
+}
+
 // IsEncrypted returns true if the track is encrypted.
 func (t *Track) IsEncrypted() bool {
 	return t.internal.Encrypted
@@ -122,4 +134,79 @@ type ProgressUpdate struct {
 
 	// Error is non-nil if the segment download failed.
 	Error error
-}
\ No newline at end of file
+
+	// Concurrency is the number of segments allowed to download at once.
+	// It changes over time when adaptive threads are enabled.
+	Concurrency int
+}
+
+// Event is a timed marker from the manifest, such as an ad break signaled by
+// EXT-X-DATERANGE, EXT-X-CUE-OUT/CUE-IN or a DASH EventStream.
+type Event struct {
+	// ID is the marker identifier from the manifest (may be empty).
+	ID string
+
+	// Class is the DATERANGE CLASS or DASH scheme URI.
+	Class string
+
+	// AdBreak is true if the event marks an ad break.
+	AdBreak bool
+
+	// Start is the presentation time relative to the start of the stream.
+	Start time.Duration
+
+	// Duration is the event length, 0 if unknown.
+	Duration time.Duration
+
+	// SCTE35 is the raw SCTE-35 payload when present.
+	SCTE35 string
+}
+
+// SessionData is a piece of playlist-level metadata from EXT-X-SESSION-DATA,
+// such as a title or language information.
+type SessionData struct {
+	// ID is the DATA-ID, e.g. "com.example.title".
+	ID string
+
+	// Value is the metadata value (empty if URI is set).
+	Value string
+
+	// URI points to a JSON document holding the value.
+	URI string
+
+	// Language is the language of the value, if specified.
+	Language string
+}
+
+// Gap is a segment that failed to download, with its track, index,
+// position in the stream and last error.
+type Gap = engine.Gap
+
+// MissingSegmentsError is returned by Download when more segments failed
+// than the failure tolerance allows. Use errors.As to get the gaps.
+type MissingSegmentsError = engine.MissingSegmentsError
+
+// ErrInvalidSegment is the error of segments whose body was truncated or
+// wasn't media, e.g. an HTML error page. Such segments are retried, and
+// show up as gaps if all attempts fail.
+var ErrInvalidSegment = engine.ErrInvalidSegment
+
+// ErrRangeIgnored is the error of byte range segments that the server
+// answered with the whole resource. They are not retried.
+var ErrRangeIgnored = engine.ErrRangeIgnored
+
+// ErrDiskSpace is returned by Download when the download won't fit on disk,
+// or when free space fell below the minimum while downloading. In the
+// latter case progress is saved, and downloading again resumes it.
+var ErrDiskSpace = engine.ErrDiskSpace
+
+// BandwidthWindow limits bandwidth during a daily window of local time.
+// Start and End are times of day (End up to 24h); a window ending before
+// it starts runs past midnight. BytesPerSec 0 = unlimited.
+type BandwidthWindow = httpclient.BandwidthWindow
+
+// ParseBandwidthSchedule parses windows like
+// "00:00-07:00=unlimited,09:00-18:00=2M". Rates take K, M and G suffixes.
+func ParseBandwidthSchedule(s string) ([]BandwidthWindow, error) {
+	return httpclient.ParseBandwidthSchedule(s)
+}
@@ -29,11 +29,15 @@ package veld
 import (
 	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
 
 	"github.com/mohaanymo/veld/internal/config"
 	"github.com/mohaanymo/veld/internal/engine"
 	"github.com/mohaanymo/veld/internal/models"
-	"github.com/mohaanymo/veld/internal/parser"
 )
 
 // Downloader is the main API for downloading media streams.
@@ -48,6 +52,12 @@ type Option func(*config.Config)
 
 // New creates a new Downloader with the given options.
 func New(opts ...Option) (*Downloader, error) {
+	return newDownloader(engine.Shared{}, opts...)
+}
+
+// newDownloader creates a Downloader that draws on limits shared with
+// other downloaders, see engine.Shared.
+func newDownloader(shared engine.Shared, opts ...Option) (*Downloader, error) {
 	cfg := config.New()
 	for _, opt := range opts {
 		opt(cfg)
@@ -57,7 +67,7 @@ func New(opts ...Option) (*Downloader, error) {
 		return nil, err
 	}
 
-	eng, err := engine.New(cfg)
+	eng, err := engine.NewShared(cfg, shared)
 	if err != nil {
 		return nil, err
 	}
@@ -96,6 +106,17 @@ func WithThreads(n int) Option {
 	}
 }
 
+// WithAdaptiveThreads tunes the number of concurrent downloads at runtime
+// between min and max: it ramps up while throughput improves and backs off
+// when the server throttles (429/503, timeouts) or latency climbs.
+func WithAdaptiveThreads(min, max int) Option {
+	return func(c *config.Config) {
+		c.AdaptiveThreads = true
+		c.AdaptiveMinThreads = min
+		c.Threads = max
+	}
+}
+
 // WithFormat sets the output format: "mp4", "mkv", or "ts" (default: "mp4").
 func WithFormat(format string) Option {
 	return func(c *config.Config) {
@@ -126,6 +147,135 @@ func WithCookies(cookies string) Option {
 	}
 }
 
+// WithCookieFile loads cookies from a Netscape cookies.txt file (as
+// exported from a browser) into a cookie jar used for all requests. The jar
+// follows Set-Cookie responses; with save, cookies changed during the
+// session are written back to the file on Close.
+func WithCookieFile(path string, save bool) Option {
+	return func(c *config.Config) {
+		c.CookieFile = path
+		c.SaveCookies = save
+	}
+}
+
+// WithUserAgent sets the User-Agent header. A User-Agent set through
+// WithHeader takes precedence.
+func WithUserAgent(ua string) Option {
+	return func(c *config.Config) {
+		c.UserAgent = ua
+	}
+}
+
+// WithHTTPClient makes veld send every request (manifests, keys and
+// segments) through client, e.g. one with a custom transport. Headers,
+// cookies, user agent and bandwidth limits are still applied on top of it;
+// the client's own transport decides proxies and TLS, so WithProxy has no
+// effect.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *config.Config) {
+		c.HTTPClient = client
+	}
+}
+
+// WithCACertificates trusts the root CAs in a PEM bundle besides the
+// system roots, e.g. for origins with a private CA.
+func WithCACertificates(file string) Option {
+	return func(c *config.Config) {
+		c.CAFile = file
+	}
+}
+
+// WithClientCertificate authenticates with a client certificate (mutual
+// TLS). Both files are PEM encoded.
+func WithClientCertificate(certFile, keyFile string) Option {
+	return func(c *config.Config) {
+		c.ClientCert = certFile
+		c.ClientKey = keyFile
+	}
+}
+
+// WithTLSServerName sends name as SNI to the manifest's host and verifies
+// its certificate against name instead of the host. Other hosts keep
+// their own name.
+func WithTLSServerName(name string) Option {
+	return func(c *config.Config) {
+		c.TLSServerName = name
+	}
+}
+
+// WithHostHeader sends host as the Host header of requests to the
+// manifest's host.
+func WithHostHeader(host string) Option {
+	return func(c *config.Config) {
+		c.HostHeader = host
+	}
+}
+
+// WithResolve connects to addr for requests to hostPort ("host:443"),
+// keeping the Host header and SNI, like curl --resolve.
+func WithResolve(hostPort, addr string) Option {
+	return func(c *config.Config) {
+		c.Resolve = append(c.Resolve, hostPort+":"+addr)
+	}
+}
+
+// WithInsecureTLS disables TLS certificate verification. Only for testing.
+func WithInsecureTLS(insecure bool) Option {
+	return func(c *config.Config) {
+		c.Insecure = insecure
+	}
+}
+
+// WithProxy routes all requests through a proxy: "http://host:port",
+// "https://...", "socks5://host:port" or "socks5h://..." (DNS through the
+// proxy), with optional "user:password@". Use "direct" to ignore the
+// HTTP_PROXY/HTTPS_PROXY environment variables, which apply by default.
+func WithProxy(proxy string) Option {
+	return func(c *config.Config) {
+		c.Proxy = proxy
+	}
+}
+
+// WithSegmentProxy routes media segment downloads through a different
+// proxy than manifests, keys and init segments, e.g. "direct" when only the
+// manifest is geo-restricted. Defaults to the WithProxy setting.
+func WithSegmentProxy(proxy string) Option {
+	return func(c *config.Config) {
+		c.SegmentProxy = proxy
+	}
+}
+
+// WithHostLimit limits traffic to each host matching pattern
+// ("origin.example.com", "*.cdn.example.com"): at most maxConns concurrent
+// requests, requestsPerSec requests per second and bytesPerSec bytes per
+// second. 0 = unlimited. The first matching pattern applies. Tasks of a
+// Manager with the same limit share it.
+func WithHostLimit(pattern string, maxConns int, requestsPerSec float64, bytesPerSec int64) Option {
+	return func(c *config.Config) {
+		c.HostLimits = append(c.HostLimits, fmt.Sprintf("%s:conns=%d,rps=%g,bw=%d",
+			pattern, maxConns, requestsPerSec, bytesPerSec))
+	}
+}
+
+// WithURLRefresh sets a callback for renewing expired signed URLs. When a
+// segment request fails with 401/403, veld calls fn for a freshly signed
+// manifest URL ("" = the original URL), fetches the manifest again and
+// continues with the new segment URLs. Completed segments are kept.
+// Without a callback the original manifest URL is fetched again.
+func WithURLRefresh(fn func(ctx context.Context) (manifestURL string, err error)) Option {
+	return func(c *config.Config) {
+		c.RefreshURL = fn
+	}
+}
+
+// WithRefreshAfter sets how many 401/403 segment responses trigger a URL
+// refresh (default: 1). Set to 0 to never refresh.
+func WithRefreshAfter(n int) Option {
+	return func(c *config.Config) {
+		c.RefreshAfter = n
+	}
+}
+
 // WithTrackSelector sets the track selection string.
 // Examples: "best", "1080p", "720p", "all", "video:0+audio:1"
 func WithTrackSelector(selector string) Option {
@@ -141,13 +291,24 @@ func WithDecryptionKeys(keys []string) Option {
 	}
 }
 
-// WithVerbose enables verbose logging.
+// WithVerbose logs diagnostics to stderr at debug level. It has no effect
+// when a logger is set with WithLogger.
 func WithVerbose(verbose bool) Option {
 	return func(c *config.Config) {
 		c.Verbose = verbose
 	}
 }
 
+// WithLogger sets the logger for diagnostics: retries, refreshed URLs,
+// failed segments, FFmpeg output and similar. Records carry attributes
+// such as track, segment, url and attempt; downloads run by a Manager also
+// carry task. By default nothing is logged.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *config.Config) {
+		c.Logger = logger
+	}
+}
+
 // WithParallelTracks enables downloading all tracks concurrently.
 func WithParallelTracks(parallel bool) Option {
 	return func(c *config.Config) {
@@ -155,6 +316,18 @@ func WithParallelTracks(parallel bool) Option {
 	}
 }
 
+// WithSchedule sets the order in which segments are downloaded:
+// "sequential" (track by track), "interleaved" (all tracks by presentation
+// time, so the start of the output is complete first) or "parallel-tracks"
+// (one segment of each track in turn). By default, WithParallelTracks picks
+// parallel-tracks, pipeline and writer output use interleaved, and other
+// downloads are sequential.
+func WithSchedule(schedule string) Option {
+	return func(c *config.Config) {
+		c.Schedule = schedule
+	}
+}
+
 // WithMaxBandwidth sets maximum download speed in bytes per second.
 // Set to 0 for unlimited (default).
 func WithMaxBandwidth(bytesPerSec int64) Option {
@@ -163,11 +336,146 @@ func WithMaxBandwidth(bytesPerSec int64) Option {
 	}
 }
 
+// WithBandwidthSchedule overrides WithMaxBandwidth during daily time
+// windows, e.g. unlimited at night and 2 MB/s during business hours. The
+// limit changes live while downloading. See ParseBandwidthSchedule.
+func WithBandwidthSchedule(windows ...BandwidthWindow) Option {
+	return func(c *config.Config) {
+		specs := make([]string, len(windows))
+		for i, w := range windows {
+			specs[i] = w.String()
+		}
+		c.BandwidthSchedule = strings.Join(specs, ",")
+	}
+}
+
+// WithBandwidthWeight sets the task's share of a Manager's global bandwidth
+// limit relative to other tasks (default 1).
+func WithBandwidthWeight(weight float64) Option {
+	return func(c *config.Config) {
+		c.BandwidthWeight = weight
+	}
+}
+
+// withTaskLogger adds the task ID to every record the download logs.
+func withTaskLogger(id string) Option {
+	return func(c *config.Config) {
+		c.Logger = c.Log().With("task", id)
+	}
+}
+
+// WithRetry sets how failed requests (manifests, keys, init and media
+// segments) are retried: up to retries times after the first attempt, with
+// exponential backoff starting at delay, giving up on a request after
+// maxTime (0 = no limit). Client errors such as 403 and 404 are not retried,
+// and a server's Retry-After is honored. Defaults: 3, 1s, 2m.
+func WithRetry(retries int, delay, maxTime time.Duration) Option {
+	return func(c *config.Config) {
+		c.RetryAttempts = retries
+		c.RetryDelay = delay
+		c.RetryMaxTime = maxTime
+	}
+}
+
+// WithFailureTolerance sets how many segments may fail (after retries)
+// before the download fails with a *MissingSegmentsError. maxCount limits
+// the number of failed segments and maxPercent their share of the download;
+// 0 disables a limit, and 0, 0 allows no failures at all. Missing segments
+// of a download that succeeds are listed in <name>.gaps.json.
+// Default: 1% of segments.
+func WithFailureTolerance(maxCount int, maxPercent float64) Option {
+	return func(c *config.Config) {
+		c.FailureTolerance = config.Tolerance{MaxCount: maxCount, MaxPercent: maxPercent}
+	}
+}
+
+// WithTimeRange downloads only the part of a VOD stream between start and end.
+// Segments covering the range are selected in every track; when FFmpeg is
+// available the output is trimmed to the exact timestamps.
+// Set end to 0 to download until the end of the stream.
+func WithTimeRange(start, end time.Duration) Option {
+	return func(c *config.Config) {
+		c.StartTime = start
+		c.EndTime = end
+	}
+}
+
+// WithSkipAds drops segments that fall inside ad breaks signaled in the manifest.
+func WithSkipAds(skip bool) Option {
+	return func(c *config.Config) {
+		c.SkipAds = skip
+	}
+}
+
+// WithEventExport writes manifest markers as chapters in the output (FFmpeg
+// only) and as a JSON sidecar next to it (<name>.events.json).
+func WithEventExport(export bool) Option {
+	return func(c *config.Config) {
+		c.ExportEvents = export
+	}
+}
+
+// WithWriter streams the output to w (e.g. stdout or an upload) instead of
+// writing a file. Segments are written in order as they finish: a single
+// track is concatenated as-is, and several tracks are muxed by FFmpeg into a
+// streamable container (fragmented MP4, MKV or TS). Subtitle and thumbnail
+// tracks are not written, and a stream can't be resumed.
+func WithWriter(w io.Writer) Option {
+	return func(c *config.Config) {
+		c.Writer = w
+	}
+}
+
+// WithSegmentCheck enables checking each segment's length against
+// Content-Length or its byte range, and sniffing its format (TS, fMP4,
+// WebVTT, TTML), so truncated bodies and error pages are downloaded again
+// (default: enabled).
+func WithSegmentCheck(enabled bool) Option {
+	return func(c *config.Config) {
+		c.NoSegmentCheck = !enabled
+	}
+}
+
+// WithTempDir sets where segments and muxing intermediates are stored
+// (default: the system temp dir). A resumed download keeps using the dir
+// it started in.
+func WithTempDir(dir string) Option {
+	return func(c *config.Config) {
+		c.TempDir = dir
+	}
+}
+
+// WithMinFreeSpace sets the free space to keep in the temp and output dirs
+// (default: 256 MB). Download fails up front if the estimated size doesn't
+// fit above it, and stops with progress saved if free space falls below it.
+func WithMinFreeSpace(bytes int64) Option {
+	return func(c *config.Config) {
+		c.MinFreeSpace = bytes
+	}
+}
+
+// WithDiskSpaceCheck enables the disk space estimate and low-disk watch
+// (default: enabled).
+func WithDiskSpaceCheck(enabled bool) Option {
+	return func(c *config.Config) {
+		c.NoSpaceCheck = !enabled
+	}
+}
+
+// WithPipeline muxes media tracks while they download instead of after the
+// last segment arrives. Segment files are deleted as soon as they are written
+// to the output, which keeps disk use low. An interrupted pipeline download
+// restarts the output from the beginning.
+func WithPipeline(enabled bool) Option {
+	return func(c *config.Config) {
+		c.Pipeline = enabled
+	}
+}
+
 // Parse fetches and parses the manifest from the configured URL.
 // Must be called before Tracks(), SelectTracks(), or Download().
 func (d *Downloader) Parse(ctx context.Context) error {
-	registry := parser.NewRegistry()
-	manifest, err := registry.Parse(ctx, d.cfg.URL, d.cfg.Headers)
+	manifest, err := d.eng.ParseManifest(ctx, d.cfg.URL)
 	if err != nil {
 		return fmt.Errorf("parse manifest: %w", err)
 	}
@@ -242,6 +550,7 @@ func (d *Downloader) Progress() <-chan ProgressUpdate {
 				BytesLoaded:  p.BytesLoaded,
 				Completed:    p.Completed,
 				Error:        p.Error,
+				Concurrency:  p.Concurrency,
 			}
 		}
 	}()
@@ -268,6 +577,44 @@ func (d *Downloader) ManifestType() string {
 	return d.manifest.Type.String()
 }
 
+// Events returns the ad markers and other timed events found in the manifest.
+// Returns nil if Parse() hasn't been called.
+func (d *Downloader) Events() []Event {
+	if d.manifest == nil {
+		return nil
+	}
+	events := make([]Event, len(d.manifest.Events))
+	for i, e := range d.manifest.Events {
+		events[i] = Event{
+			ID:       e.ID,
+			Class:    e.Class,
+			AdBreak:  e.IsAdBreak(),
+			Start:    e.Start,
+			Duration: e.Duration,
+			SCTE35:   e.SCTE35,
+		}
+	}
+	return events
+}
+
+// SessionData returns the EXT-X-SESSION-DATA metadata of an HLS master playlist.
+// Returns nil if Parse() hasn't been called or the manifest has none.
+func (d *Downloader) SessionData() []SessionData {
+	if d.manifest == nil {
+		return nil
+	}
+	data := make([]SessionData, len(d.manifest.SessionData))
+	for i, sd := range d.manifest.SessionData {
+		data[i] = SessionData{
+			ID:       sd.ID,
+			Value:    sd.Value,
+			URI:      sd.URI,
+			Language: sd.Language,
+		}
+	}
+	return data
+}
+
 // DownloadURL is a convenience function for simple downloads.
 // It parses the manifest, selects tracks (using "best" or configured selector),
 // and downloads to the specified output path.